@@ -1,10 +1,13 @@
 package cli
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/yamavol/go-argp"
 	"github.com/yamavol/greload/lib"
@@ -12,15 +15,52 @@ import (
 )
 
 const (
-	flagPort    = "port"
-	flagVerbose = "verbose"
-	flagLevel   = "level"
-	flagDelay   = "delay"
-	flagWatch   = "watch"
-	flagExclude = "exclude"
-	flagCmd     = "cmd"
-	flagHelp    = "help"
-	flagVersion = "version"
+	flagPort           = "port"
+	flagVerbose        = "verbose"
+	flagLevel          = "level"
+	flagDelay          = "delay"
+	flagWatch          = "watch"
+	flagExclude        = "exclude"
+	flagCmd            = "cmd"
+	flagReload         = "reload-on-error"
+	flagAtStart        = "cmd-at-start"
+	flagBuilding       = "building-page"
+	flagStrict         = "strict"
+	flagOutput         = "output"
+	flagCmdArg         = "cmd-arg"
+	flagCmdDir         = "cmd-dir"
+	flagCmdEnv         = "cmd-env"
+	flagWait           = "wait-upstream"
+	flagHTTPS          = "https"
+	flagCert           = "cert"
+	flagKey            = "key"
+	flagCertHost       = "cert-host"
+	flagUpCA           = "upstream-ca"
+	flagUpCert         = "upstream-cert"
+	flagUpKey          = "upstream-key"
+	flagInsecure       = "insecure"
+	flagRoute          = "route"
+	flagStatic         = "static"
+	flagFallback       = "fallback"
+	flagMount          = "mount"
+	flagRewrite        = "rewrite-body"
+	flagCookieDomain   = "cookie-domain"
+	flagCookieSite     = "cookie-samesite"
+	flagCookieInsecure = "cookie-insecure"
+	flagHostHeader     = "host-header"
+	flagReqHeader      = "request-header"
+	flagResHeader      = "response-header"
+	flagResHeaderFile  = "response-headers-file"
+	flagNoCache        = "no-cache"
+	flagNoSW           = "unregister-sw"
+	flagInjectOnly     = "inject-only"
+	flagNoInject       = "no-inject"
+	flagForceInject    = "force-inject"
+	flagSniff          = "sniff"
+	flagToken          = "token"
+	flagNoKeys         = "no-keys"
+	flagHelp           = "help"
+	flagVersion        = "version"
 
 	Version = "0.2.0"
 )
@@ -30,7 +70,44 @@ var Options = []argp.Option{
 	{Short: 'w', Long: flagWatch, ArgName: "<path>", Doc: "add path to watch list"},
 	{Short: 'x', Long: flagExclude, ArgName: "<path>", Doc: "add path to ignore list"},
 	{Short: 'd', Long: flagDelay, ArgName: "<ms>", Doc: "time delay (ms) before reloading"},
-	{Short: 'c', Long: flagCmd, ArgName: "<string>", Doc: "command to execute on change"},
+	{Short: 's', Long: flagStatic, ArgName: "<dir>", Doc: "serve files in <dir> (default . if no upstream)"},
+	{Short: 'm', Long: flagMount, ArgName: "<prefix=dir>", Doc: "serve path prefix from local directory (watched)"},
+	{Long: flagRewrite, Doc: "rewrite upstream URLs in HTML and CSS to the proxy"},
+	{Long: flagInjectOnly, ArgName: "<glob>", Doc: "inject reload script only in matching paths"},
+	{Long: flagNoInject, ArgName: "<glob>", Doc: "never inject reload script in matching paths"},
+	{Long: flagForceInject, ArgName: "<glob>", Doc: "always inject reload script in matching paths"},
+	{Long: flagSniff, Doc: "detect HTML responses without Content-Type"},
+	{Long: flagToken, ArgName: "<token>", Doc: "require bearer token for the control API (/__greload/)"},
+	{Long: flagNoKeys, Doc: "disable interactive keys in the terminal"},
+	{Long: flagNoCache, Doc: "disable browser caching of proxied responses"},
+	{Long: flagNoSW, Doc: "unregister service workers of the page"},
+	{Long: flagHostHeader, ArgName: "<mode>", Doc: "host header to upstream: upstream, preserve or <host>"},
+	{Long: flagReqHeader, ArgName: "<header>", Doc: "set request header \"Name: value\", or remove \"-Name\""},
+	{Long: flagResHeader, ArgName: "<rule>", Doc: "change response header, in the form of\n\"set|add|remove Name[: value][; path=<glob>][; type=<glob>]\""},
+	{Long: flagResHeaderFile, ArgName: "<file>", Doc: "read response header rules from file, one per line"},
+	{Long: flagCookieDomain, ArgName: "<domain>", Doc: "replace cookie Domain (- to remove)"},
+	{Long: flagCookieSite, ArgName: "<value>", Doc: "replace cookie SameSite (- to remove)"},
+	{Long: flagCookieInsecure, Doc: "remove cookie Secure attribute"},
+	{Long: flagFallback, ArgName: "<file>", Flags: argp.OPTION_ARG_OPTIONAL, Doc: "serve <file> for unknown pages (default index.html)"},
+	{Short: 'r', Long: flagRoute, ArgName: "<prefix=url>", Doc: "forward path prefix to another upstream, with options\n<prefix=url>[,strip][,cookie-domain=<domain>]\n[,cookie-samesite=<value>][,cookie-insecure]"},
+	{Long: flagHTTPS, Doc: "serve https with a local development certificate"},
+	{Long: flagCert, ArgName: "<file>", Doc: "certificate file for https (with --key)"},
+	{Long: flagKey, ArgName: "<file>", Doc: "private key file for https (with --cert)"},
+	{Long: flagCertHost, ArgName: "<host>", Doc: "add hostname to the local certificate"},
+	{Long: flagUpCA, ArgName: "<file>", Doc: "trust CA bundle for https upstream"},
+	{Long: flagUpCert, ArgName: "<file>", Doc: "client certificate for https upstream"},
+	{Long: flagUpKey, ArgName: "<file>", Doc: "client private key for https upstream"},
+	{Long: flagInsecure, Doc: "skip certificate verification of https upstream"},
+	{Long: flagWait, ArgName: "<ms>", Doc: "retry refused upstream connections up to <ms>"},
+	{Short: 'c', Long: flagCmd, ArgName: "<string>", Doc: "command to execute on change\n(shell string, or JSON array to run without shell)"},
+	{Long: flagCmdArg, ArgName: "<arg>", Doc: "add argument to the command run without shell"},
+	{Long: flagCmdDir, ArgName: "<path>", Doc: "working directory of the command"},
+	{Long: flagCmdEnv, ArgName: "<key=value>", Doc: "add environment variable to the command"},
+	{Long: flagReload, Doc: "reload even if the command fails"},
+	{Long: flagAtStart, Doc: "run the command once at startup"},
+	{Long: flagBuilding, Doc: "serve a building page until the startup command ends\n(implies --cmd-at-start)"},
+	{Long: flagStrict, Doc: "exit if the startup command fails (implies --cmd-at-start)"},
+	{Long: flagOutput, ArgName: "<path>", Doc: "add path written by the command (not reloaded)"},
 	{Short: 'v', Long: flagVerbose, Flags: argp.OPTION_HIDDEN, Doc: "enable verbose mode"},
 	{Short: 'h', Long: flagHelp, Flags: argp.OPTION_HIDDEN, Doc: "print help and exit"},
 	{Short: 'V', Long: flagVersion, Flags: argp.OPTION_HIDDEN, Doc: "print version and exit"},
@@ -38,12 +115,27 @@ var Options = []argp.Option{
 
 func Run() {
 
+	if runSubcommand(os.Args[1:]) {
+		return
+	}
+
 	host := ""
 	port := lib.DefaultPort
 	watch := []string{}
 	exclude := []string{}
+	outputs := []string{}
+	certHosts := []string{}
+	routes := []string{}
+	mounts := []string{}
+	reqHeaders := []string{}
+	resHeaders := []string{}
+	resHeaderFiles := []string{}
+	injectOnly := []string{}
+	noInject := []string{}
+	forceInject := []string{}
 	delay := 0
-	cmd := ""
+	wait := 0
+	cmd := lib.Command{}
 
 	// ==============================
 	// parse command line arguments
@@ -70,13 +162,18 @@ func Run() {
 		return
 	}
 
-	// argument 1 : forwarding host
-	if len(result.Args) == 0 {
-		printHelp()
-		return
-	} else {
+	// argument 1 : forwarding host (serve files if not given)
+	if len(result.Args) > 0 {
 		host = result.Args[0]
 	}
+	static := result.GetOpt(flagStatic).WithDefault("")
+	if host != "" && static != "" {
+		log.Error("upstream host and --static cannot be used together")
+		return
+	}
+	if host == "" && static == "" && !result.HasOpt(flagRoute) {
+		static = "."
+	}
 
 	// options
 	if result.HasOpt(flagPort) {
@@ -101,8 +198,24 @@ func Run() {
 		delay = max(0, d)
 	}
 
+	if result.HasOpt(flagWait) {
+		w, err := strconv.Atoi(result.GetOpt(flagWait).Optarg)
+		if err != nil {
+			log.Errorf("invalid wait value: %s\n", err)
+			return
+		}
+		wait = max(0, w)
+	}
+
 	if result.HasOpt(flagCmd) {
-		cmd = result.GetOpt(flagCmd).Optarg
+		if err = parseCmd(result.GetOpt(flagCmd).Optarg, &cmd); err != nil {
+			log.Errorf("invalid command: %s\n", err)
+			return
+		}
+	}
+
+	if result.HasOpt(flagCmdDir) {
+		cmd.Dir = result.GetOpt(flagCmdDir).Optarg
 	}
 
 	// multipe options
@@ -112,19 +225,138 @@ func Run() {
 			watch = append(watch, opt.Optarg)
 		case flagExclude:
 			exclude = append(exclude, opt.Optarg)
+		case flagOutput:
+			outputs = append(outputs, opt.Optarg)
+		case flagCertHost:
+			certHosts = append(certHosts, opt.Optarg)
+		case flagRoute:
+			routes = append(routes, opt.Optarg)
+		case flagMount:
+			mounts = append(mounts, opt.Optarg)
+		case flagReqHeader:
+			reqHeaders = append(reqHeaders, opt.Optarg)
+		case flagResHeader:
+			resHeaders = append(resHeaders, opt.Optarg)
+		case flagResHeaderFile:
+			resHeaderFiles = append(resHeaderFiles, opt.Optarg)
+		case flagInjectOnly:
+			injectOnly = append(injectOnly, opt.Optarg)
+		case flagNoInject:
+			noInject = append(noInject, opt.Optarg)
+		case flagForceInject:
+			forceInject = append(forceInject, opt.Optarg)
+		case flagCmdArg:
+			cmd.Args = append(cmd.Args, opt.Optarg)
+		case flagCmdEnv:
+			if !strings.Contains(opt.Optarg, "=") {
+				log.Errorf("invalid environment variable: %s\n", opt.Optarg)
+				return
+			}
+			cmd.Env = append(cmd.Env, opt.Optarg)
 		default:
 		}
 	}
 
+	if result.HasOpt(flagCmd) && result.HasOpt(flagCmdArg) {
+		log.Error("--cmd and --cmd-arg cannot be used together")
+		return
+	}
+
 	// ==============================
 	// server options
 	// ==============================
 	serverOptions := lib.NewServerOption()
 	serverOptions.Cmd = cmd
+	if cmd.IsEmpty() {
+		for _, flag := range []string{flagAtStart, flagBuilding, flagStrict} {
+			if result.HasOpt(flag) {
+				log.Errorf("--%s requires --cmd", flag)
+				return
+			}
+		}
+	}
+	serverOptions.ReloadOnError = result.HasOpt(flagReload)
+	serverOptions.CmdAtStart = result.HasOpt(flagAtStart)
+	serverOptions.BuildingPage = result.HasOpt(flagBuilding)
+	serverOptions.Strict = result.HasOpt(flagStrict)
+	serverOptions.Outputs = outputs
+	serverOptions.OnReloadLoop = func() {
+		log.Warnf("[loop] check that --%s does not write to watched paths (see --%s)", flagCmd, flagOutput)
+	}
+	serverOptions.HTTPS = result.HasOpt(flagHTTPS)
+	serverOptions.CertFile = result.GetOpt(flagCert).WithDefault("")
+	serverOptions.KeyFile = result.GetOpt(flagKey).WithDefault("")
+	serverOptions.CertHosts = certHosts
+	serverOptions.UpstreamCA = result.GetOpt(flagUpCA).WithDefault("")
+	serverOptions.UpstreamCert = result.GetOpt(flagUpCert).WithDefault("")
+	serverOptions.UpstreamKey = result.GetOpt(flagUpKey).WithDefault("")
+	serverOptions.Insecure = result.HasOpt(flagInsecure)
+	serverOptions.RewriteBody = result.HasOpt(flagRewrite)
+	serverOptions.NoCache = result.HasOpt(flagNoCache)
+	serverOptions.InjectOnly = injectOnly
+	serverOptions.NoInject = noInject
+	serverOptions.ForceInject = forceInject
+	serverOptions.Sniff = result.HasOpt(flagSniff)
+	serverOptions.Token = result.GetOpt(flagToken).WithDefault("")
+	serverOptions.UnregisterServiceWorkers = result.HasOpt(flagNoSW)
+	serverOptions.Cookies = lib.CookieRewrite{
+		Domain:   result.GetOpt(flagCookieDomain).WithDefault(""),
+		SameSite: result.GetOpt(flagCookieSite).WithDefault(""),
+		Insecure: result.HasOpt(flagCookieInsecure),
+	}
 
-	if err = serverOptions.SetForwardHost(host); err != nil {
-		log.Error(err)
-		return
+	if result.HasOpt(flagFallback) {
+		serverOptions.Fallback = result.GetOpt(flagFallback).WithDefault(lib.DefaultFallback)
+	}
+
+	if host != "" {
+		if err = serverOptions.SetForwardHost(host); err != nil {
+			log.Error(err)
+			return
+		}
+	}
+
+	for _, route := range routes {
+		if err = serverOptions.AddRoute(route); err != nil {
+			log.Error(err)
+			return
+		}
+	}
+
+	serverOptions.HostHeader = result.GetOpt(flagHostHeader).WithDefault(lib.HostUpstream)
+	for _, header := range reqHeaders {
+		if err = serverOptions.AddRequestHeader(header); err != nil {
+			log.Error(err)
+			return
+		}
+	}
+
+	// rules in files first, so that the command line can override them
+	for _, file := range resHeaderFiles {
+		if err = serverOptions.LoadResponseHeaders(file); err != nil {
+			log.Error(err)
+			return
+		}
+	}
+	for _, header := range resHeaders {
+		if err = serverOptions.AddResponseHeader(header); err != nil {
+			log.Error(err)
+			return
+		}
+	}
+
+	for _, mount := range mounts {
+		if err = serverOptions.AddMount(mount); err != nil {
+			log.Error(err)
+			return
+		}
+	}
+
+	if static != "" {
+		if err = serverOptions.SetStaticDir(static); err != nil {
+			log.Error(err)
+			return
+		}
 	}
 
 	if err = serverOptions.SetPort(port); err != nil {
@@ -137,20 +369,62 @@ func Run() {
 		return
 	}
 
+	if err = serverOptions.SetUpstreamWait(wait); err != nil {
+		log.Error(err)
+		return
+	}
+
 	// ==============================
 	// watch options
 	// ==============================
+	if len(watch) == 0 && static != "" {
+		watch = append(watch, static)
+	}
 	if len(watch) == 0 {
 		watch = append(watch, ".")
 	}
+	if len(mounts) > 0 {
+		// mounted files are served from disk, reload on change
+		watch = append(watch, serverOptions.LocalDirs()...)
+	}
 
 	proxyServer := lib.NewServer(serverOptions)
 
 	go lib.WatchStart(watch, exclude, proxyServer)
 
-	proxyServer.Start()
+	err = serve(proxyServer, !result.HasOpt(flagNoKeys), !serverOptions.Cmd.IsEmpty())
+	if err != nil {
+		log.Error("Error:", err)
+		os.Exit(1)
+	}
+}
+
+// Runs the server until it stops, with the interactive keys if enabled. The
+// terminal is restored when the server stops, even on panic.
+func serve(srv *lib.ProxyServer, keys bool, hasCmd bool) error {
+	if keys {
+		restore := startKeys(srv, hasCmd)
+		defer restore()
+	}
+	return srv.Start()
+}
+
+// Parses the --cmd value. A JSON array of strings is an argv, and anything
+// else is a shell command line, such as "[ -d dist ] || mkdir dist".
+func parseCmd(s string, cmd *lib.Command) error {
+	var args []string
+	if err := json.Unmarshal([]byte(s), &args); err != nil {
+		cmd.Line = s
+		return nil
+	}
+	if len(args) == 0 {
+		return errors.New("empty argument list")
+	}
+	cmd.Args = args
+	return nil
 }
 
 func printHelp() {
-	argp.PrintUsage(os.Stdout, Options, filepath.Base(os.Args[0]), "HOST:PORT")
+	argp.PrintUsage(os.Stdout, Options, filepath.Base(os.Args[0]), "[HOST:PORT]")
+	printSubcommands()
 }
@@ -0,0 +1,26 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/yamavol/greload/lib"
+	"github.com/yamavol/greload/test/harness"
+)
+
+func Test_parseCmd(t *testing.T) {
+	var cmd lib.Command
+	harness.IsNil(t, parseCmd(`["go", "build", "./..."]`, &cmd), "")
+	harness.IsEqual(t, len(cmd.Args), 3, "JSON array is an argv")
+	harness.IsEqual(t, cmd.Line, "", "")
+
+	cmd = lib.Command{}
+	harness.IsNil(t, parseCmd("[ -d dist ] || mkdir dist", &cmd), "")
+	harness.IsEqual(t, cmd.Line, "[ -d dist ] || mkdir dist", "shell test command is a shell line")
+	harness.IsEqual(t, len(cmd.Args), 0, "")
+
+	cmd = lib.Command{}
+	harness.IsNil(t, parseCmd("make build", &cmd), "")
+	harness.IsEqual(t, cmd.Line, "make build", "")
+
+	harness.IsNotNil(t, parseCmd("[]", &cmd), "empty argv")
+}
@@ -0,0 +1,217 @@
+package cli
+
+// ============================================================
+// Client subcommands for a running instance
+// ============================================================
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yamavol/go-argp"
+	"github.com/yamavol/greload/lib"
+	"github.com/yamavol/greload/log"
+)
+
+const flagCSS = "css"
+
+// subcommand runs with the arguments after its name, and returns an error
+// to exit with status 1.
+type subcommand struct {
+	args string
+	doc  string
+	run  func(instance lib.InstanceState, args []string, result argp.ParseResult) error
+}
+
+var subcommands = map[string]subcommand{
+	"trigger": {args: "[paths...]", doc: "reload the pages, or stylesheets with --css", run: runTrigger},
+	"status":  {doc: "print the state of the running instance", run: runStatus},
+	"clients": {doc: "list the connected pages", run: runClients},
+}
+
+var clientOptions = []argp.Option{
+	{Short: 'p', Long: flagPort, ArgName: "<port>", Doc: "port of the instance (found automatically if omitted)"},
+	{Long: flagToken, ArgName: "<token>", Doc: "bearer token of the control API"},
+	{Long: flagCSS, Doc: "reload stylesheets only (trigger)"},
+	{Short: 'v', Long: flagVerbose, Flags: argp.OPTION_HIDDEN, Doc: "enable verbose mode"},
+	{Short: 'h', Long: flagHelp, Flags: argp.OPTION_HIDDEN, Doc: "print help and exit"},
+}
+
+// Runs the subcommand named by the first argument. Returns false if there is
+// no such subcommand. A host with the name of a subcommand is given after
+// "--", or with its port.
+func runSubcommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	name := args[0]
+	sub, ok := subcommands[name]
+	if !ok {
+		return false
+	}
+
+	result, err := argp.ParseArgs(clientOptions, args[1:])
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		os.Exit(1)
+	}
+	if result.HasOpt(flagVerbose) {
+		log.SetLogLevel(log.LevelDebug)
+	}
+	if result.HasOpt(flagHelp) {
+		fmt.Printf("%s\n\n", sub.doc)
+		argp.PrintUsage(os.Stdout, clientOptions, filepath.Base(os.Args[0])+" "+name, sub.args)
+		return true
+	}
+
+	port := 0
+	if result.HasOpt(flagPort) {
+		port, err = strconv.Atoi(result.GetOpt(flagPort).Optarg)
+		if err != nil {
+			log.Errorf("invalid port: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	instance, err := lib.FindInstance(port)
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	if result.HasOpt(flagToken) {
+		instance.Token = result.GetOpt(flagToken).Optarg
+	}
+
+	if err = sub.run(instance, result.Args, result); err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	return true
+}
+
+func runTrigger(instance lib.InstanceState, args []string, result argp.ParseResult) error {
+	req := lib.ReloadRequest{CSS: result.HasOpt(flagCSS), Paths: args}
+	var status lib.Status
+	if err := callControl(instance, http.MethodPost, "reload", req, &status); err != nil {
+		return err
+	}
+	fmt.Printf("reload sent to %d client(s)\n", status.Clients)
+	return nil
+}
+
+func runStatus(instance lib.InstanceState, args []string, result argp.ParseResult) error {
+	var status lib.Status
+	if err := callControl(instance, http.MethodGet, "status", nil, &status); err != nil {
+		return err
+	}
+
+	reload := "active"
+	if status.Paused {
+		reload = "paused"
+	}
+	build := "ok"
+	if !status.Command {
+		build = "n/a"
+	} else if status.Building {
+		build = "building"
+	} else if status.BuildError != "" {
+		build = "failed"
+	}
+
+	fmt.Printf("greload on %s://127.0.0.1:%d", instance.Scheme, instance.Port)
+	if instance.PID != 0 {
+		fmt.Printf(" (pid %d)", instance.PID)
+	}
+	fmt.Printf("\nreload:  %s\nbuild:   %s\nclients: %d\n", reload, build, status.Clients)
+	if status.BuildError != "" {
+		fmt.Printf("\n%s\n", status.BuildError)
+	}
+	return nil
+}
+
+func runClients(instance lib.InstanceState, args []string, result argp.ParseResult) error {
+	var clients []lib.Client
+	if err := callControl(instance, http.MethodGet, "clients", nil, &clients); err != nil {
+		return err
+	}
+	printClients(clients)
+	return nil
+}
+
+func printClients(clients []lib.Client) {
+	if len(clients) == 0 {
+		fmt.Println("no clients connected")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PAGE\tADDRESS\tCONNECTED\tUSER AGENT")
+	for _, client := range clients {
+		since := time.Since(client.Since).Round(time.Second)
+		fmt.Fprintf(w, "%s\t%s\t%s ago\t%s\n", client.Page, client.Address, since, client.UserAgent)
+	}
+	w.Flush()
+}
+
+// Calls the control API of the instance, and decodes the JSON response to
+// out.
+func callControl(instance lib.InstanceState, method string, endpoint string, in any, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, instance.ControlURL(endpoint), body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if instance.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+instance.Token)
+	}
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			// the instance runs on the loopback with a local certificate
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("greload is not running on port %d: %w", instance.Port, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Prints the subcommands in the help.
+func printSubcommands() {
+	fmt.Println("\nCommands for a running instance (with -p <port>, --token <token>):")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, name := range []string{"trigger", "status", "clients"} {
+		sub := subcommands[name]
+		fmt.Fprintf(w, " %s %s\t%s\n", name, sub.args, sub.doc)
+	}
+	w.Flush()
+	fmt.Println("\nTo forward to a host named like a command, use \"-- <host>\" or \"<host>:<port>\".")
+}
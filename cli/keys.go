@@ -0,0 +1,69 @@
+package cli
+
+// ============================================================
+// Interactive keys in the terminal
+// ============================================================
+
+import (
+	"os"
+	"unicode"
+
+	"github.com/yamavol/greload/lib"
+	"github.com/yamavol/greload/log"
+)
+
+const keysHelp = "press r to reload, p to pause/resume, c to run the command, l to list clients, q to quit"
+
+// Reads the key presses in the terminal, and controls the server. Returns
+// the function to restore the terminal. Keys are disabled if stdin is not a
+// terminal.
+func startKeys(srv *lib.ProxyServer, hasCmd bool) func() {
+	restore, err := enableKeyInput(os.Stdin)
+	if err != nil {
+		log.Debug("[key] interactive keys disabled:", err)
+		return func() {}
+	}
+
+	log.Info(keysHelp)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				return
+			}
+			if n == 1 {
+				handleKey(srv, hasCmd, buf[0])
+			}
+		}
+	}()
+	return restore
+}
+
+func handleKey(srv *lib.ProxyServer, hasCmd bool, key byte) {
+	switch unicode.ToLower(rune(key)) {
+	case 'r':
+		log.Info("[key] reloading")
+		srv.Reload()
+	case 'p':
+		if srv.Paused() {
+			srv.Resume()
+		} else {
+			srv.Pause()
+		}
+	case 'c':
+		if !hasCmd {
+			log.Info("[key] no command to run (see --cmd)")
+			return
+		}
+		log.Info("[key] running the command")
+		srv.TriggerReload()
+	case 'l':
+		printClients(srv.Clients())
+	case 'q':
+		log.Info("[key] quitting")
+		srv.Stop()
+	case 'h', '?':
+		log.Info(keysHelp)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package cli
+
+import (
+	"errors"
+	"os"
+)
+
+func enableKeyInput(f *os.File) (func(), error) {
+	return nil, errors.New("not supported on this platform")
+}
@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Reads the console input key by key, without echo. Ctrl-C is kept. Returns
+// the function to restore the console, or an error if the input is not a
+// console.
+func enableKeyInput(f *os.File) (func(), error) {
+	handle := windows.Handle(f.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return nil, err
+	}
+	keys := mode &^ (windows.ENABLE_LINE_INPUT | windows.ENABLE_ECHO_INPUT)
+	if err := windows.SetConsoleMode(handle, keys); err != nil {
+		return nil, err
+	}
+	return func() {
+		windows.SetConsoleMode(handle, mode)
+	}, nil
+}
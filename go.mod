@@ -7,9 +7,6 @@ require (
 	github.com/mattn/go-ieproxy v0.0.12
 	github.com/yamavol/go-argp v0.1.1
 	golang.org/x/net v0.47.0
-)
-
-require (
-	golang.org/x/sys v0.38.0 // indirect
-	golang.org/x/text v0.31.0 // indirect
+	golang.org/x/sys v0.38.0
+	golang.org/x/text v0.31.0
 )
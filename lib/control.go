@@ -0,0 +1,182 @@
+package lib
+
+// ============================================================
+// Control API for external tools
+// ============================================================
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yamavol/greload/log"
+)
+
+// ControlPrefix is the path reserved for the control API. Requests under
+// it are never forwarded to the upstream.
+const ControlPrefix = "/__greload/"
+
+// ReloadRequest is the body of POST /__greload/reload. An empty body
+// reloads all pages.
+type ReloadRequest struct {
+	CSS   bool     `json:"css,omitempty"`   // reload stylesheets only
+	Paths []string `json:"paths,omitempty"` // globs of pages, or stylesheets
+}
+
+// Status is the response of the control API.
+type Status struct {
+	Paused     bool   `json:"paused"`
+	Command    bool   `json:"command"`
+	Building   bool   `json:"building"`
+	Clients    int    `json:"clients"`
+	BuildError string `json:"buildError,omitempty"`
+}
+
+// Client is a connected reload client.
+type Client struct {
+	Page      string    `json:"page"`
+	Address   string    `json:"address"`
+	UserAgent string    `json:"userAgent"`
+	Since     time.Time `json:"since"`
+}
+
+// The reload script tells its page in the query of the websocket URL.
+func newClient(r *http.Request) Client {
+	return Client{
+		Page:      r.URL.Query().Get("page"),
+		Address:   r.RemoteAddr,
+		UserAgent: r.UserAgent(),
+		Since:     time.Now(),
+	}
+}
+
+// Clients returns the connected reload clients, oldest first.
+func (srv *ProxyServer) Clients() []Client {
+	srv.mu.Lock()
+	clients := make([]Client, 0, len(srv.connections))
+	for _, client := range srv.connections {
+		clients = append(clients, client)
+	}
+	srv.mu.Unlock()
+	slices.SortFunc(clients, func(a, b Client) int { return a.Since.Compare(b.Since) })
+	return clients
+}
+
+// Status returns the current state of the server.
+func (srv *ProxyServer) Status() Status {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return Status{
+		Paused:     srv.paused.Load(),
+		Command:    !srv.options.Cmd.IsEmpty(),
+		Building:   srv.building.Load(),
+		Clients:    len(srv.connections),
+		BuildError: srv.buildError,
+	}
+}
+
+// Reload reloads the pages matching the path globs, or all pages, without
+// running the command.
+func (srv *ProxyServer) Reload(paths ...string) {
+	srv.broadcast(message{Type: msgReload, Paths: paths})
+}
+
+// ReloadCSS reloads the stylesheets matching the path globs, or all
+// stylesheets, without reloading the pages.
+func (srv *ProxyServer) ReloadCSS(paths ...string) {
+	srv.broadcast(message{Type: msgCSS, Paths: paths})
+}
+
+func isControlPath(path string) bool {
+	return strings.HasPrefix(path, ControlPrefix)
+}
+
+func controlHandler(srv *ProxyServer) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST "+ControlPrefix+"reload", func(w http.ResponseWriter, r *http.Request) {
+		var req ReloadRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "invalid reload request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.CSS {
+			log.Info("[api] stylesheet reload requested")
+			srv.ReloadCSS(req.Paths...)
+		} else {
+			log.Info("[api] reload requested")
+			srv.Reload(req.Paths...)
+		}
+		writeStatus(w, srv)
+	})
+	mux.HandleFunc("GET "+ControlPrefix+"status", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, srv)
+	})
+	mux.HandleFunc("GET "+ControlPrefix+"clients", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, srv.Clients())
+	})
+	mux.HandleFunc("POST "+ControlPrefix+"pause", func(w http.ResponseWriter, r *http.Request) {
+		srv.Pause()
+		writeStatus(w, srv)
+	})
+	mux.HandleFunc("POST "+ControlPrefix+"resume", func(w http.ResponseWriter, r *http.Request) {
+		srv.Resume()
+		writeStatus(w, srv)
+	})
+
+	return requireToken(srv.options.Token, mux)
+}
+
+// Rejects the requests without "Authorization: Bearer <token>", if the
+// token is set. Without token, it rejects the requests from other sites, so
+// that pages opened in the browser cannot control the server.
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return sameOrigin(next)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Rejects the cross-site requests of the browsers. Tools do not send
+// Origin nor Sec-Fetch-Site, and pass.
+func sameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+			http.Error(w, "cross-site request", http.StatusForbidden)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Host != r.Host {
+				http.Error(w, "cross-origin request", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeStatus(w http.ResponseWriter, srv *ProxyServer) {
+	writeJSON(w, srv.Status())
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,132 @@
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yamavol/greload/test/harness"
+	"golang.org/x/net/websocket"
+)
+
+func newControlServer(t *testing.T, token string) (*ProxyServer, *httptest.Server) {
+	opt := NewServerOption()
+	opt.SetForwardHost("http://127.0.0.1:1")
+	opt.Token = token
+	srv := NewServer(opt)
+	handler, err := serverHandler(srv)
+	harness.IsNil(t, err, "")
+	return srv, httptest.NewServer(http.HandlerFunc(handler))
+}
+
+func control(t *testing.T, method string, url string, body string, token string) (*http.Response, Status) {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var status Status
+	json.NewDecoder(resp.Body).Decode(&status)
+	return resp, status
+}
+
+func Test_controlStatus(t *testing.T) {
+	srv, ts := newControlServer(t, "")
+	defer ts.Close()
+
+	resp, status := control(t, "GET", ts.URL+"/__greload/status", "", "")
+	harness.IsEqual(t, resp.StatusCode, 200, "")
+	harness.IsFalse(t, status.Paused, "")
+	harness.IsFalse(t, status.Command, "no command")
+
+	_, status = control(t, "POST", ts.URL+"/__greload/pause", "", "")
+	harness.IsTrue(t, status.Paused, "paused")
+	harness.IsTrue(t, srv.Paused(), "")
+
+	_, status = control(t, "POST", ts.URL+"/__greload/resume", "", "")
+	harness.IsFalse(t, status.Paused, "resumed")
+
+	resp, _ = control(t, "GET", ts.URL+"/__greload/reload", "", "")
+	harness.IsEqual(t, resp.StatusCode, http.StatusMethodNotAllowed, "reload needs POST")
+	resp, _ = control(t, "GET", ts.URL+"/__greload/unknown", "", "")
+	harness.IsEqual(t, resp.StatusCode, http.StatusNotFound, "not forwarded to the upstream")
+	resp, _ = control(t, "POST", ts.URL+"/__greload/reload", "{", "")
+	harness.IsEqual(t, resp.StatusCode, http.StatusBadRequest, "")
+}
+
+func Test_controlToken(t *testing.T) {
+	_, ts := newControlServer(t, "secret")
+	defer ts.Close()
+
+	resp, _ := control(t, "GET", ts.URL+"/__greload/status", "", "")
+	harness.IsEqual(t, resp.StatusCode, http.StatusUnauthorized, "token is required")
+	resp, _ = control(t, "GET", ts.URL+"/__greload/status", "", "wrong")
+	harness.IsEqual(t, resp.StatusCode, http.StatusUnauthorized, "")
+	resp, _ = control(t, "GET", ts.URL+"/__greload/status", "", "secret")
+	harness.IsEqual(t, resp.StatusCode, 200, "")
+}
+
+func Test_controlCrossSite(t *testing.T) {
+	srv, ts := newControlServer(t, "")
+	defer ts.Close()
+
+	post := func(header string, value string) int {
+		req, _ := http.NewRequest("POST", ts.URL+"/__greload/pause", strings.NewReader(""))
+		req.Header.Set(header, value)
+		resp, err := http.DefaultClient.Do(req)
+		harness.IsNil(t, err, "")
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	harness.IsEqual(t, post("Origin", "http://evil.example"), http.StatusForbidden, "other origin")
+	harness.IsEqual(t, post("Origin", "null"), http.StatusForbidden, "opaque origin")
+	harness.IsEqual(t, post("Sec-Fetch-Site", "cross-site"), http.StatusForbidden, "")
+	harness.IsFalse(t, srv.Paused(), "cross-site requests do not pause")
+
+	harness.IsEqual(t, post("Origin", ts.URL), 200, "page of the proxy")
+	harness.IsTrue(t, srv.Paused(), "")
+	harness.IsEqual(t, post("Sec-Fetch-Site", "same-origin"), 200, "")
+}
+
+func Test_controlReload(t *testing.T) {
+	srv, ts := newControlServer(t, "")
+	defer ts.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/?page=%2Fdocs%2F"
+	conn, err := websocket.Dial(wsURL, "", ts.URL)
+	harness.IsNil(t, err, "")
+	defer conn.Close()
+
+	// wait for the server to register the client
+	for i := 0; i < 100 && srv.Status().Clients == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	harness.IsEqual(t, srv.Status().Clients, 1, "")
+
+	var clients []Client
+	resp, err := http.Get(ts.URL + "/__greload/clients")
+	harness.IsNil(t, err, "")
+	json.NewDecoder(resp.Body).Decode(&clients)
+	resp.Body.Close()
+	harness.IsEqual(t, len(clients), 1, "")
+	harness.IsEqual(t, clients[0].Page, "/docs/", "page of the client")
+
+	var msg message
+	control(t, "POST", ts.URL+"/__greload/reload", "", "")
+	harness.IsNil(t, websocket.JSON.Receive(conn, &msg), "")
+	harness.IsEqual(t, msg.Type, msgReload, "full reload")
+	harness.IsEqual(t, len(msg.Paths), 0, "")
+
+	control(t, "POST", ts.URL+"/__greload/reload", `{"css":true,"paths":["/css/*"]}`, "")
+	harness.IsNil(t, websocket.JSON.Receive(conn, &msg), "")
+	harness.IsEqual(t, msg.Type, msgCSS, "stylesheet reload")
+	harness.IsEqual(t, strings.Join(msg.Paths, ","), "/css/*", "")
+}
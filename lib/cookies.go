@@ -0,0 +1,93 @@
+package lib
+
+// ============================================================
+// Set-Cookie rewriting
+// ============================================================
+
+import (
+	"net/http"
+	"strings"
+)
+
+// CookieRewrite changes the attributes of the cookies set by an upstream,
+// so that they work on the proxy origin.
+type CookieRewrite struct {
+	Domain   string // replace the Domain attribute, "-" removes it
+	SameSite string // replace the SameSite attribute, "-" removes it
+	Insecure bool   // remove the Secure attribute
+}
+
+func (c CookieRewrite) isZero() bool {
+	return c == CookieRewrite{}
+}
+
+// Sets a cookie option in route rule form, such as "cookie-domain=-".
+func (c *CookieRewrite) setOption(key string, value string) bool {
+	switch key {
+	case "cookie-domain":
+		c.Domain = value
+	case "cookie-samesite":
+		c.SameSite = value
+	case "cookie-insecure":
+		c.Insecure = true
+	default:
+		return false
+	}
+	return true
+}
+
+// Rewrites the Set-Cookie headers of the response. The cookie path gets the
+// route prefix back if the prefix was stripped.
+func rewriteCookies(resp *http.Response, prefix string, c CookieRewrite) {
+	cookies := resp.Header.Values("Set-Cookie")
+	if len(cookies) == 0 || (c.isZero() && prefix == "") {
+		return
+	}
+	resp.Header.Del("Set-Cookie")
+	for _, cookie := range cookies {
+		resp.Header.Add("Set-Cookie", rewriteCookie(cookie, prefix, c))
+	}
+}
+
+func rewriteCookie(cookie string, prefix string, c CookieRewrite) string {
+	parts := strings.Split(cookie, ";")
+	out := []string{parts[0]}
+	sameSite := -1 // index of SameSite in out
+
+	for _, part := range parts[1:] {
+		attr := strings.TrimSpace(part)
+		key, value, _ := strings.Cut(attr, "=")
+		value = strings.TrimSpace(value)
+
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "domain":
+			if c.Domain == "-" {
+				continue
+			} else if c.Domain != "" {
+				attr = "Domain=" + c.Domain
+			}
+		case "secure":
+			if c.Insecure {
+				continue
+			}
+		case "samesite":
+			if c.SameSite == "-" {
+				continue
+			} else if c.SameSite != "" {
+				attr = "SameSite=" + c.SameSite
+			}
+			sameSite = len(out)
+		case "path":
+			if prefix != "" && strings.HasPrefix(value, "/") {
+				attr = "Path=" + prefix + value
+			}
+		}
+		out = append(out, attr)
+	}
+
+	// browsers reject SameSite=None without Secure
+	if c.Insecure && sameSite >= 0 && strings.EqualFold(out[sameSite], "SameSite=None") {
+		out[sameSite] = "SameSite=Lax"
+	}
+	return strings.Join(out, "; ")
+}
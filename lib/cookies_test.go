@@ -0,0 +1,42 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/yamavol/greload/test/harness"
+)
+
+func Test_rewriteCookie(t *testing.T) {
+	cookie := "sid=abc; Path=/; Domain=app.local; Secure; HttpOnly; SameSite=None"
+
+	harness.IsEqual(t, rewriteCookie(cookie, "", CookieRewrite{}), cookie, "unchanged without options")
+
+	harness.IsEqual(t,
+		rewriteCookie(cookie, "", CookieRewrite{Domain: "-", Insecure: true}),
+		"sid=abc; Path=/; HttpOnly; SameSite=Lax",
+		"SameSite=None requires Secure, so it becomes Lax",
+	)
+
+	harness.IsEqual(t,
+		rewriteCookie(cookie, "/api", CookieRewrite{Domain: "127.0.0.1", SameSite: "-"}),
+		"sid=abc; Path=/api/; Domain=127.0.0.1; Secure; HttpOnly",
+		"",
+	)
+
+	harness.IsEqual(t,
+		rewriteCookie("a=1; path=/auth", "/api", CookieRewrite{}),
+		"a=1; Path=/api/auth",
+		"path gets the stripped prefix",
+	)
+}
+
+func Test_ParseRouteCookieOptions(t *testing.T) {
+	r, err := ParseRoute("/api=localhost:8080,strip,cookie-domain=-,cookie-insecure")
+	harness.IsNil(t, err, "")
+	harness.IsTrue(t, r.StripPrefix, "")
+	harness.IsTrue(t, r.Cookies != nil, "")
+	harness.IsEqual(t, *r.Cookies, CookieRewrite{Domain: "-", Insecure: true}, "")
+
+	r, _ = ParseRoute("/api=localhost:8080")
+	harness.IsTrue(t, r.Cookies == nil, "no cookie options")
+}
@@ -0,0 +1,74 @@
+package lib
+
+// ============================================================
+// History fallback for single-page apps
+// ============================================================
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/yamavol/greload/log"
+)
+
+const DefaultFallback = "index.html"
+
+// fallbackTransport serves the fallback page for the page requests that
+// would be 404, so that the paths routed in the browser can be reloaded.
+type fallbackTransport struct {
+	transport http.RoundTripper
+	page      string // path of the fallback page
+}
+
+func (t *fallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusNotFound || !wantsFallback(req) {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	log.Debugf("[fallback] %s -> %s", req.URL.Path, t.page)
+	out := req.Clone(req.Context())
+	out.URL.Path = fallbackPath(req, t.page)
+	out.URL.RawPath = ""
+	out.URL.RawQuery = ""
+	return t.transport.RoundTrip(out)
+}
+
+// Returns true if the request is a page navigation.
+func wantsFallback(req *http.Request) bool {
+	if req.Method != http.MethodGet {
+		return false
+	}
+	if !strings.Contains(req.Header.Get("Accept"), "text/html") {
+		return false
+	}
+	// paths with an extension are assets, except for html pages
+	switch path.Ext(req.URL.Path) {
+	case "", ".html", ".htm":
+		return true
+	default:
+		return false
+	}
+}
+
+// Returns the upstream path of the fallback page. The page is relative to the
+// route prefix, and is forwarded the same way as the page requests.
+func fallbackPath(req *http.Request, page string) string {
+	route, ok := routeFrom(req.Context())
+	if !ok {
+		return fallbackRequestPath(page)
+	}
+	return fallbackRequestPath(route.forwardPath(path.Join(route.Prefix, page)))
+}
+
+// Returns the path to request the fallback page. Index pages are requested
+// by their directory, since file servers redirect /index.html to ./
+func fallbackRequestPath(page string) string {
+	page = "/" + strings.TrimLeft(page, "/")
+	if path.Base(page) == "index.html" {
+		return strings.TrimSuffix(page, "index.html")
+	}
+	return page
+}
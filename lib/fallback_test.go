@@ -0,0 +1,65 @@
+package lib
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yamavol/greload/test/harness"
+)
+
+func Test_fallback(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html><body>app</body></html>"), 0o644)
+
+	opt := NewServerOption()
+	opt.SetStaticDir(dir)
+	opt.Fallback = DefaultFallback
+	proxy := newTestProxy(t, opt)
+
+	resp, body := doRequest(t, "GET", proxy.URL+"/settings/profile", "Accept", "text/html,*/*")
+	harness.IsEqual(t, resp.StatusCode, 200, "deep link serves the fallback")
+	harness.IsTrue(t, strings.HasPrefix(body, "<html><body>app"), "fallback page is served")
+	harness.IsTrue(t, strings.Contains(body, "<script>"), "reload script is injected")
+
+	resp, _ = doRequest(t, "GET", proxy.URL+"/missing.js", "Accept", "text/html,*/*")
+	harness.IsEqual(t, resp.StatusCode, 404, "assets are not replaced")
+
+	resp, _ = doRequest(t, "GET", proxy.URL+"/settings/profile", "Accept", "application/json")
+	harness.IsEqual(t, resp.StatusCode, 404, "non-page requests are not replaced")
+}
+
+func Test_fallbackRequestPath(t *testing.T) {
+	harness.IsEqual(t, fallbackRequestPath("index.html"), "/", "")
+	harness.IsEqual(t, fallbackRequestPath("/app/index.html"), "/app/", "")
+	harness.IsEqual(t, fallbackRequestPath("app.html"), "/app.html", "")
+}
+
+func Test_fallbackRoute(t *testing.T) {
+	upstream := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/base/app/":
+			w.Write([]byte("<html><body>app</body></html>"))
+		case "/base/":
+			w.Write([]byte("<html><body>admin</body></html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	opt := NewServerOption()
+	opt.AddRoute("/app=" + upstream.URL + "/base")
+	opt.AddRoute("/admin=" + upstream.URL + "/base,strip")
+	opt.Fallback = DefaultFallback
+	proxy := newTestProxy(t, opt)
+
+	resp, body := doRequest(t, "GET", proxy.URL+"/app/settings", "Accept", "text/html")
+	harness.IsEqual(t, resp.StatusCode, 200, "")
+	harness.IsTrue(t, strings.HasPrefix(body, "<html><body>app"), "fallback under the target path and prefix")
+
+	resp, body = doRequest(t, "GET", proxy.URL+"/admin/users/1", "Accept", "text/html")
+	harness.IsEqual(t, resp.StatusCode, 200, "")
+	harness.IsTrue(t, strings.HasPrefix(body, "<html><body>admin"), "fallback with the prefix stripped")
+}
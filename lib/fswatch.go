@@ -73,7 +73,7 @@ func WatchStart(watch []string, exclude []string, srv *ProxyServer) {
 				}
 				log.Debug("[fs]", "event", event)
 				if event.Op&fsnotify.Write == fsnotify.Write {
-					srv.TriggerReload()
+					srv.notifyChange(event.Name)
 				}
 			case err, ok := <-watcher.Errors:
 				if !ok {
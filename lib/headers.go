@@ -0,0 +1,165 @@
+package lib
+
+// ============================================================
+// Header rewriting
+// ============================================================
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Host header modes of the forwarded requests. Any other value of
+// ServerOptions.HostHeader is sent as the Host header.
+const (
+	HostUpstream = "upstream" // the upstream host (default)
+	HostPreserve = "preserve" // the host requested by the browser
+)
+
+// HeaderRule changes a header of the proxied requests or responses.
+type HeaderRule struct {
+	Action string // "set", "add" or "remove"
+	Name   string
+	Value  string
+
+	// Conditions of response rules. The glob "*" matches any characters,
+	// including "/". Empty matches all.
+	Path        string
+	ContentType string
+}
+
+// ParseRequestHeader parses a request header rule. "Name: value" sets the
+// header, and "-Name" removes it.
+func ParseRequestHeader(rule string) (HeaderRule, error) {
+	if name, ok := strings.CutPrefix(rule, "-"); ok {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return HeaderRule{}, fmt.Errorf("invalid header %q: name is empty", rule)
+		}
+		return HeaderRule{Action: "remove", Name: name}, nil
+	}
+	name, value, ok := strings.Cut(rule, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return HeaderRule{}, fmt.Errorf("invalid header %q: expected \"Name: value\" or \"-Name\"", rule)
+	}
+	return HeaderRule{Action: "set", Name: name, Value: strings.TrimSpace(value)}, nil
+}
+
+// ParseResponseHeader parses a response header rule in
+// "ACTION NAME[: VALUE][; path=GLOB][; type=GLOB]" form, such as
+// "set Cache-Control: no-store; type=text/html". ACTION is one of set, add
+// and remove.
+func ParseResponseHeader(rule string) (HeaderRule, error) {
+	var r HeaderRule
+
+	// the conditions are at the end, the value may contain ";"
+	parts := strings.Split(rule, ";")
+	for len(parts) > 1 {
+		key, value, _ := strings.Cut(strings.TrimSpace(parts[len(parts)-1]), "=")
+		if key == "path" {
+			r.Path = strings.TrimSpace(value)
+		} else if key == "type" {
+			r.ContentType = strings.TrimSpace(value)
+		} else {
+			break
+		}
+		parts = parts[:len(parts)-1]
+	}
+
+	action, header, _ := strings.Cut(strings.TrimSpace(strings.Join(parts, ";")), " ")
+	name, value, hasValue := strings.Cut(header, ":")
+	r.Action = action
+	r.Name = strings.TrimSpace(name)
+	r.Value = strings.TrimSpace(value)
+
+	switch {
+	case r.Action != "set" && r.Action != "add" && r.Action != "remove":
+		return HeaderRule{}, fmt.Errorf("invalid header rule %q: unknown action %q", rule, r.Action)
+	case r.Name == "":
+		return HeaderRule{}, fmt.Errorf("invalid header rule %q: name is empty", rule)
+	case r.Action != "remove" && !hasValue:
+		return HeaderRule{}, fmt.Errorf("invalid header rule %q: value is required", rule)
+	}
+	return r, nil
+}
+
+// Returns true if the response rule applies to the path and content type.
+func (r HeaderRule) matches(path string, contentType string) bool {
+	if r.Path != "" && !globMatch(r.Path, path) {
+		return false
+	}
+	if r.ContentType != "" {
+		mediaType, _, _ := strings.Cut(contentType, ";")
+		if !globMatch(r.ContentType, strings.TrimSpace(mediaType)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if s matches the pattern. "*" matches any characters.
+func globMatch(pattern string, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return s == pattern
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// Applies the rule to the header.
+func (r HeaderRule) apply(h http.Header) {
+	switch r.Action {
+	case "set":
+		h.Set(r.Name, r.Value)
+	case "add":
+		h.Add(r.Name, r.Value)
+	case "remove":
+		h.Del(r.Name)
+	}
+}
+
+// Returns the Host header sent to the upstream, or "" to use the upstream
+// host.
+func (s *ServerOptions) upstreamHostHeader(r *http.Request) string {
+	switch s.HostHeader {
+	case "", HostUpstream:
+		return ""
+	case HostPreserve:
+		return r.Host
+	default:
+		return s.HostHeader
+	}
+}
+
+// Removes the validators and caching headers, so the browser always asks.
+func disableCache(h http.Header) {
+	for _, key := range []string{"ETag", "Last-Modified", "Expires", "Age"} {
+		h.Del(key)
+	}
+	h.Set("Cache-Control", "no-store")
+	h.Set("Pragma", "no-cache")
+}
+
+// Removes the headers that make the upstream reply 304 Not Modified to
+// GET and HEAD. The preconditions of the other requests, and If-Range that
+// goes with Range, are kept.
+func removeConditionalHeaders(r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return
+	}
+	r.Header.Del("If-None-Match")
+	r.Header.Del("If-Modified-Since")
+}
@@ -0,0 +1,160 @@
+package lib
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yamavol/greload/test/harness"
+)
+
+func Test_ParseRequestHeader(t *testing.T) {
+	r, err := ParseRequestHeader("X-Debug: 1")
+	harness.IsNil(t, err, "")
+	harness.IsEqual(t, r, HeaderRule{Action: "set", Name: "X-Debug", Value: "1"}, "")
+
+	r, err = ParseRequestHeader("-Cookie")
+	harness.IsNil(t, err, "")
+	harness.IsEqual(t, r, HeaderRule{Action: "remove", Name: "Cookie"}, "")
+
+	_, err = ParseRequestHeader("X-Debug")
+	harness.IsNotNil(t, err, "value is required")
+	_, err = ParseRequestHeader("-")
+	harness.IsNotNil(t, err, "name is required")
+}
+
+func Test_upstreamRequestHeaders(t *testing.T) {
+	upstream := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host + "|" + r.Header.Get("X-Debug") + "|" + r.Header.Get("X-Remove")))
+	})
+
+	get := func(opt *ServerOptions) string {
+		_, body := doRequest(t, "GET", newTestProxy(t, opt).URL, "Host", "myapp.test", "X-Remove", "1")
+		return body
+	}
+
+	opt := NewServerOption()
+	opt.SetForwardHost(upstream.URL)
+	harness.IsEqual(t, get(opt), upstream.Listener.Addr().String()+"||1", "upstream host by default")
+
+	opt.HostHeader = HostPreserve
+	harness.IsEqual(t, get(opt), "myapp.test||1", "browser host is preserved")
+
+	opt.HostHeader = "fixed.test"
+	opt.AddRequestHeader("X-Debug: on")
+	opt.AddRequestHeader("-X-Remove")
+	harness.IsEqual(t, get(opt), "fixed.test|on|", "fixed host and header rules")
+}
+
+func Test_ParseResponseHeader(t *testing.T) {
+	r, err := ParseResponseHeader("set Cache-Control: no-store; type=text/html")
+	harness.IsNil(t, err, "")
+	harness.IsEqual(t, r, HeaderRule{Action: "set", Name: "Cache-Control", Value: "no-store", ContentType: "text/html"}, "")
+
+	r, err = ParseResponseHeader("add Content-Security-Policy: default-src 'self'; img-src *; path=/api/*")
+	harness.IsNil(t, err, "")
+	harness.IsEqual(t, r.Value, "default-src 'self'; img-src *", "value may contain ;")
+	harness.IsEqual(t, r.Path, "/api/*", "")
+
+	r, err = ParseResponseHeader("remove X-Frame-Options")
+	harness.IsNil(t, err, "")
+	harness.IsEqual(t, r, HeaderRule{Action: "remove", Name: "X-Frame-Options"}, "")
+
+	_, err = ParseResponseHeader("replace X-Frame-Options: x")
+	harness.IsNotNil(t, err, "unknown action")
+	_, err = ParseResponseHeader("set X-Frame-Options")
+	harness.IsNotNil(t, err, "value is required")
+}
+
+func Test_HeaderRuleMatches(t *testing.T) {
+	r := HeaderRule{Path: "/api/*", ContentType: "application/*"}
+	harness.IsTrue(t, r.matches("/api/v1/users", "application/json; charset=utf-8"), "")
+	harness.IsFalse(t, r.matches("/static/app.js", "application/json"), "path does not match")
+	harness.IsFalse(t, r.matches("/api/index", "text/html"), "type does not match")
+	harness.IsTrue(t, HeaderRule{}.matches("/", ""), "no condition matches all")
+}
+
+func Test_responseHeaderRules(t *testing.T) {
+	upstream := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Cache-Control", "max-age=3600")
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("{}"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html></html>"))
+	})
+
+	opt := NewServerOption()
+	opt.SetForwardHost(upstream.URL)
+	opt.AddResponseHeader("remove X-Frame-Options")
+	opt.AddResponseHeader("set Access-Control-Allow-Origin: *; type=application/json")
+	opt.AddResponseHeader("set Cache-Control: no-store; path=/pages/*; type=text/html")
+	proxy := newTestProxy(t, opt)
+
+	resp, _ := doRequest(t, "GET", proxy.URL+"/api/data")
+	harness.IsEqual(t, resp.Header.Get("X-Frame-Options"), "", "header is removed")
+	harness.IsEqual(t, resp.Header.Get("Access-Control-Allow-Origin"), "*", "CORS header is set for JSON")
+	harness.IsEqual(t, resp.Header.Get("Cache-Control"), "max-age=3600", "cache header of JSON is kept")
+
+	resp, body := doRequest(t, "GET", proxy.URL+"/pages/index.html")
+	harness.IsEqual(t, resp.Header.Get("Access-Control-Allow-Origin"), "", "no CORS header for HTML")
+	harness.IsEqual(t, resp.Header.Get("Cache-Control"), "no-store", "cache header is set for the HTML path")
+	harness.IsTrue(t, len(body) > len("<html></html>"), "rules apply after injection")
+
+	resp, _ = doRequest(t, "GET", proxy.URL+"/other.html")
+	harness.IsEqual(t, resp.Header.Get("Cache-Control"), "max-age=3600", "other paths are not changed")
+}
+
+func Test_LoadResponseHeaders(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "headers.txt")
+	os.WriteFile(file, []byte("# development headers\n\nremove X-Frame-Options\nset Cache-Control: no-store; type=text/html\n"), 0o644)
+
+	opt := NewServerOption()
+	harness.IsNil(t, opt.LoadResponseHeaders(file), "")
+	harness.IsEqual(t, len(opt.ResponseHeaders), 2, "comments and blank lines are skipped")
+	harness.IsEqual(t, opt.ResponseHeaders[1].Value, "no-store", "")
+
+	os.WriteFile(file, []byte("remove X-Frame-Options\nreplace X-Debug: 1\n"), 0o644)
+	err := opt.LoadResponseHeaders(file)
+	harness.IsNotNil(t, err, "invalid rule")
+	harness.IsTrue(t, strings.Contains(err.Error(), "headers.txt:2"), "error has the line number")
+}
+
+func Test_noCache(t *testing.T) {
+	upstream := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			if r.Header.Get("If-Match") != `"v2"` {
+				w.WriteHeader(http.StatusPreconditionFailed)
+			}
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=31536000")
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html></html>"))
+	})
+
+	opt := NewServerOption()
+	opt.SetForwardHost(upstream.URL)
+	opt.NoCache = true
+	opt.UnregisterServiceWorkers = true
+	proxy := newTestProxy(t, opt)
+
+	resp, body := doRequest(t, "GET", proxy.URL, "If-None-Match", `"v1"`)
+	harness.IsEqual(t, resp.StatusCode, 200, "conditional request gets the full page")
+	harness.IsEqual(t, resp.Header.Get("ETag"), "", "validator is removed")
+	harness.IsEqual(t, resp.Header.Get("Cache-Control"), "no-store", "")
+	harness.IsTrue(t, strings.Contains(body, `{"unregisterServiceWorkers":true}`), "client option is injected")
+
+	resp, _ = doRequest(t, "PUT", proxy.URL, "If-Match", `"v1"`)
+	harness.IsEqual(t, resp.StatusCode, http.StatusPreconditionFailed, "precondition of PUT is kept")
+}
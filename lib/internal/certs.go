@@ -0,0 +1,191 @@
+package internal
+
+// ============================================================
+// Local development certificates
+// ============================================================
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	caCertFile   = "ca.pem"
+	caKeyFile    = "ca-key.pem"
+	leafCertFile = "cert.pem"
+	leafKeyFile  = "key.pem"
+
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 365 * 24 * time.Hour
+	renewBefore  = 7 * 24 * time.Hour
+)
+
+// DefaultCertHosts are always covered by the generated certificate.
+var DefaultCertHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+// LocalCert returns a certificate for the hosts, signed by the local
+// development CA. The CA and the certificate are created in dir if missing,
+// and the certificate is renewed if it expires or does not cover the hosts.
+// The path of the CA certificate is returned, so users can trust it.
+func LocalCert(dir string, hosts []string) (tls.Certificate, string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return tls.Certificate{}, "", err
+	}
+
+	caPath := filepath.Join(dir, caCertFile)
+	ca, caKey, err := loadOrCreateCA(caPath, filepath.Join(dir, caKeyFile))
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+
+	certPath := filepath.Join(dir, leafCertFile)
+	keyPath := filepath.Join(dir, leafKeyFile)
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err == nil && leafIsValid(cert.Leaf, ca, hosts) {
+		return cert, caPath, nil
+	}
+
+	if err = createLeaf(certPath, keyPath, ca, caKey, hosts); err != nil {
+		return tls.Certificate{}, "", err
+	}
+	cert, err = tls.LoadX509KeyPair(certPath, keyPath)
+	return cert, caPath, err
+}
+
+func loadOrCreateCA(certPath string, keyPath string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err == nil && time.Now().Before(pair.Leaf.NotAfter) {
+		if key, ok := pair.PrivateKey.(*ecdsa.PrivateKey); ok {
+			return pair.Leaf, key, nil
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"greload development CA"},
+			CommonName:   "greload development CA",
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = writePem(certPath, keyPath, der, key); err != nil {
+		return nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(der)
+	return ca, key, err
+}
+
+func createLeaf(certPath string, keyPath string, ca *x509.Certificate, caKey *ecdsa.PrivateKey, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"greload development certificate"},
+		},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(leafValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range allHosts(hosts) {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	return writePem(certPath, keyPath, der, key)
+}
+
+// Returns true if the leaf is signed by ca, covers all hosts, and is not
+// about to expire.
+func leafIsValid(leaf *x509.Certificate, ca *x509.Certificate, hosts []string) bool {
+	if leaf == nil || time.Now().Add(renewBefore).After(leaf.NotAfter) {
+		return false
+	}
+	if leaf.CheckSignatureFrom(ca) != nil {
+		return false
+	}
+	for _, h := range allHosts(hosts) {
+		if leaf.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func allHosts(hosts []string) []string {
+	all := slices.Clone(DefaultCertHosts)
+	for _, h := range hosts {
+		if !slices.Contains(all, h) {
+			all = append(all, h)
+		}
+	}
+	return all
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func writePem(certPath string, keyPath string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+	if certPem == nil || keyPem == nil {
+		return errors.New("failed to encode certificate")
+	}
+	if err = os.WriteFile(keyPath, keyPem, 0o600); err != nil {
+		return fmt.Errorf("write key: %w", err)
+	}
+	if err = os.WriteFile(certPath, certPem, 0o644); err != nil {
+		return fmt.Errorf("write certificate: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package internal_test
+
+import (
+	"crypto/x509"
+	"os"
+	"testing"
+
+	"github.com/yamavol/greload/lib/internal"
+	"github.com/yamavol/greload/test/harness"
+)
+
+func Test_LocalCert(t *testing.T) {
+	dir := t.TempDir()
+
+	cert, caPath, err := internal.LocalCert(dir, []string{"myapp.test"})
+	harness.IsNil(t, err, "certificate should be created")
+
+	caPem, err := os.ReadFile(caPath)
+	harness.IsNil(t, err, "CA should be written")
+	roots := x509.NewCertPool()
+	harness.IsTrue(t, roots.AppendCertsFromPEM(caPem), "CA should be PEM")
+
+	for _, host := range []string{"localhost", "127.0.0.1", "myapp.test"} {
+		_, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots})
+		harness.IsNil(t, err, "certificate should be valid for "+host)
+	}
+
+	again, _, err := internal.LocalCert(dir, []string{"myapp.test"})
+	harness.IsNil(t, err, "")
+	harness.IsEqual(t, again.Leaf.SerialNumber.String(), cert.Leaf.SerialNumber.String(), "certificate should be reused")
+
+	renewed, _, err := internal.LocalCert(dir, []string{"other.test"})
+	harness.IsNil(t, err, "")
+	harness.IsNotEqual(t, renewed.Leaf.SerialNumber.String(), cert.Leaf.SerialNumber.String(), "certificate should be renewed for new hosts")
+	_, err = renewed.Leaf.Verify(x509.VerifyOptions{DNSName: "other.test", Roots: roots})
+	harness.IsNil(t, err, "renewed certificate should be signed by the same CA")
+}
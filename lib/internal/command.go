@@ -1,26 +1,84 @@
 package internal
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"os"
 	"os/exec"
 	"runtime"
+	"strings"
+	"sync"
 )
 
-// ExecuteCommand executes a shell command in an OS-independent manner.
-// On Windows, it uses cmd /C, on Unix-like systems it uses sh -c.
-func ExecuteCommand(command string) error {
+// Command describes a command to execute. If Args is set, the program is
+// executed directly without a shell, otherwise Line is run by the shell.
+type Command struct {
+	Line string   // shell command line
+	Args []string // program and its arguments
+	Dir  string   // working directory, current directory if empty
+	Env  []string // extra environment variables in "KEY=VALUE" form
+}
+
+// IsEmpty returns true if there is nothing to execute.
+func (c Command) IsEmpty() bool {
+	return len(c.Args) == 0 && strings.TrimSpace(c.Line) == ""
+}
+
+func (c Command) String() string {
+	if len(c.Args) > 0 {
+		return strings.Join(c.Args, " ")
+	}
+	return c.Line
+}
+
+// ExecuteCommand executes a command in an OS-independent manner.
+// Shell command lines use cmd /C on Windows, and sh -c on Unix-like systems.
+//
+// The output is printed to stdout and stderr as it runs, and the combined
+// output is also returned to the caller.
+func ExecuteCommand(command Command) (string, error) {
 	var cmd *exec.Cmd
 
-	// Determine shell based on OS
-	if runtime.GOOS == "windows" {
-		cmd = exec.Command("cmd", "/C", command)
+	if len(command.Args) > 0 {
+		cmd = exec.Command(command.Args[0], command.Args[1:]...)
+	} else if command.Line == "" {
+		return "", errors.New("empty command")
+	} else if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command.Line)
 	} else {
-		cmd = exec.Command("sh", "-c", command)
+		cmd = exec.Command("sh", "-c", command.Line)
 	}
 
-	// Inherit stdout and stderr
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Dir = command.Dir
+	if len(command.Env) > 0 {
+		cmd.Env = append(os.Environ(), command.Env...)
+	}
+
+	// Inherit stdout and stderr, and keep a copy of both
+	var output lockedBuffer
+	cmd.Stdout = io.MultiWriter(os.Stdout, &output)
+	cmd.Stderr = io.MultiWriter(os.Stderr, &output)
+
+	err := cmd.Run()
+	return output.String(), err
+}
+
+// lockedBuffer is a bytes.Buffer safe for concurrent writes from the
+// stdout and stderr copying goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
 
-	return cmd.Run()
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
 }
@@ -0,0 +1,55 @@
+package internal_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yamavol/greload/lib/internal"
+	"github.com/yamavol/greload/test/harness"
+)
+
+func Test_ExecuteCommandOutput(t *testing.T) {
+	out, err := internal.ExecuteCommand(internal.Command{Line: "echo hello"})
+	harness.IsNil(t, err, "command should succeed")
+	harness.IsEqual(t, strings.TrimSpace(out), "hello", "output should be captured")
+
+	out, err = internal.ExecuteCommand(internal.Command{Line: "echo failed && exit 3"})
+	harness.IsNotNil(t, err, "command should fail")
+	harness.IsEqual(t, strings.TrimSpace(out), "failed", "output should be captured on failure")
+}
+
+func Test_ExecuteCommandArgs(t *testing.T) {
+	exe, err := os.Executable()
+	harness.IsNil(t, err, "")
+
+	// run the test binary itself, which exists without a shell
+	out, err := internal.ExecuteCommand(internal.Command{
+		Args: []string{exe, "-test.run=Test_HelperPrintEnv"},
+		Dir:  os.TempDir(),
+		Env:  []string{"GRELOAD_HELPER=with space"},
+	})
+	harness.IsNil(t, err, "command should succeed")
+	harness.IsTrue(t, strings.Contains(out, "env: with space"), "extra env should be passed")
+	// resolve symlinks such as /var -> /private/var on macOS
+	dir, err := filepath.EvalSymlinks(os.TempDir())
+	harness.IsNil(t, err, "")
+	harness.IsTrue(t, strings.Contains(out, "dir: "+dir+"\n"), "working directory should be set")
+}
+
+func Test_HelperPrintEnv(t *testing.T) {
+	v, ok := os.LookupEnv("GRELOAD_HELPER")
+	if !ok {
+		t.Skip("helper process only")
+	}
+	wd, _ := os.Getwd()
+	wd, _ = filepath.EvalSymlinks(wd)
+	os.Stdout.WriteString("env: " + v + "\ndir: " + wd + "\n")
+}
+
+func Test_CommandIsEmpty(t *testing.T) {
+	harness.IsTrue(t, internal.Command{}.IsEmpty(), "")
+	harness.IsTrue(t, internal.Command{Line: "  "}.IsEmpty(), "")
+	harness.IsFalse(t, internal.Command{Args: []string{"go"}}.IsEmpty(), "")
+}
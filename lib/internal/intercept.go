@@ -4,10 +4,15 @@ import (
 	"bytes"
 	"context"
 	_ "embed"
+	"encoding/json"
 	"io"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
 )
 
 //go:embed reload-client.js
@@ -17,13 +22,55 @@ var injectHtml = "\n<script>\n" + reloadCode + "\n</script>\n"
 const keyContentType = "Content-Type"
 const keyContentLength = "Content-Length"
 
+// ClientOptions configures the reload script and its injection.
+type ClientOptions struct {
+	Port                     int  `json:"-"` // websocket server port
+	UnregisterServiceWorkers bool `json:"unregisterServiceWorkers"`
+	Sniff                    bool `json:"-"` // detect HTML without Content-Type
+}
+
+// ReloadScript returns the reload script in a <script> tag.
+func ReloadScript(opts ClientOptions) []byte {
+	config, _ := json.Marshal(opts)
+	script := strings.ReplaceAll(injectHtml, "9765", strconv.Itoa(opts.Port))
+	script = strings.Replace(script, "/*options*/{}", string(config), 1)
+	return []byte(script)
+}
+
+// XHTML is parsed as XML, the script must be CDATA
+func reloadScriptXhtml(opts ClientOptions) []byte {
+	script := ReloadScript(opts)
+	script = bytes.Replace(script, []byte("<script>\n"), []byte("<script>//<![CDATA[\n"), 1)
+	script = bytes.Replace(script, []byte("\n</script>"), []byte("\n//]]></script>"), 1)
+	return script
+}
+
 // For ReverseProxy.ModifyResponse. Injects reload script in HTML response.
-func ResponseModifier(wsport int) func(*http.Response) error {
+func ResponseModifier(opts ClientOptions) func(*http.Response) error {
+	return injector(opts, false)
+}
 
-	var scriptHtml = []byte(strings.ReplaceAll(injectHtml, "9765", strconv.Itoa(wsport)))
+// For ReverseProxy.ModifyResponse. Injects reload script in any response with
+// a body, regardless of its content type and the request.
+func ForcedResponseModifier(opts ClientOptions) func(*http.Response) error {
+	return injector(opts, true)
+}
+
+func injector(opts ClientOptions, force bool) func(*http.Response) error {
+
+	var scriptHtml = ReloadScript(opts)
+	var scriptXhtml = reloadScriptXhtml(opts)
 
 	return func(resp *http.Response) error {
-		if !strings.HasPrefix(resp.Header.Get(keyContentType), "text/html") {
+		contentType := resp.Header.Get(keyContentType)
+		sniff := opts.Sniff && contentType == ""
+		if !force && !sniff && !isHtmlType(contentType) {
+			return nil
+		}
+		if !hasWholeBody(resp) {
+			return nil
+		}
+		if !force && resp.Request != nil && !isDocumentRequest(resp.Request) {
 			return nil
 		}
 		body, err := io.ReadAll(resp.Body)
@@ -34,14 +81,134 @@ func ResponseModifier(wsport int) func(*http.Response) error {
 			return err
 		}
 
-		body = append(body, scriptHtml...)
+		if !force && sniff && !isHtmlType(http.DetectContentType(body)) {
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+			return nil
+		}
+
+		enc := documentEncoding(body, contentType)
+		if !force && !hasDocumentStructure(decode(enc, body)) {
+			// fragment of a page, leave it as it is
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+			return nil
+		}
+
+		if isXhtmlType(contentType) {
+			body = insertBeforeClose(body, encode(enc, scriptXhtml), enc)
+		} else {
+			body = append(body, encode(enc, scriptHtml)...)
+		}
 		resp.Body = io.NopCloser(bytes.NewReader(body))
 		resp.ContentLength = int64(len(body))
 		resp.Header.Set(keyContentLength, strconv.Itoa(len(body)))
+
+		// byte ranges of the upstream body do not match the injected body
+		resp.Header.Del("Accept-Ranges")
 		return nil
 	}
 }
 
+// Returns the media type of Content-Type in lower case, without parameters.
+func mediaType(contentType string) string {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
+
+func isHtmlType(contentType string) bool {
+	return mediaType(contentType) == "text/html" || isXhtmlType(contentType)
+}
+
+func isXhtmlType(contentType string) bool {
+	return mediaType(contentType) == "application/xhtml+xml"
+}
+
+// Inserts the snippet before </body>, or </html>. Nothing may follow the
+// root element of XHTML.
+func insertBeforeClose(body []byte, snippet []byte, enc encoding.Encoding) []byte {
+	for _, tag := range []string{"</body>", "</html>"} {
+		if i := bytes.LastIndex(body, encode(enc, []byte(tag))); i >= 0 {
+			return slices.Concat(body[:i], snippet, body[i:])
+		}
+	}
+	return append(body, snippet...)
+}
+
+// Returns false for the responses without a body (HEAD, 1xx, 204, 304), and
+// partial responses (206). Their headers describe the upstream body, and
+// must not be changed.
+func hasWholeBody(resp *http.Response) bool {
+	if resp.Request != nil && resp.Request.Method == http.MethodHead {
+		return false
+	}
+	switch {
+	case resp.StatusCode < 200,
+		resp.StatusCode == http.StatusNoContent,
+		resp.StatusCode == http.StatusNotModified,
+		resp.StatusCode == http.StatusPartialContent:
+		return false
+	default:
+		return true
+	}
+}
+
+// Returns false for the requests of page fragments, such as fetch(), XHR,
+// htmx and Turbo requests.
+func isDocumentRequest(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Dest") {
+	case "", "document", "iframe", "frame":
+	default:
+		return false
+	}
+	return r.Header.Get("HX-Request") == "" && r.Header.Get("X-Requested-With") == ""
+}
+
+// Returns the encoding of the HTML, determined by BOM, Content-Type charset
+// or <meta charset>, or nil if the document is UTF-8.
+func documentEncoding(body []byte, contentType string) encoding.Encoding {
+	enc, name, _ := charset.DetermineEncoding(body, contentType)
+	if name == "utf-8" {
+		return nil
+	}
+	return enc
+}
+
+// Converts the document to UTF-8. Returns the body as it is on failure.
+func decode(enc encoding.Encoding, body []byte) []byte {
+	if enc == nil {
+		return body
+	}
+	decoded, err := enc.NewDecoder().Bytes(body)
+	if err != nil {
+		return body
+	}
+	return decoded
+}
+
+// Converts the UTF-8 snippet to the document encoding.
+func encode(enc encoding.Encoding, snippet []byte) []byte {
+	if enc == nil {
+		return snippet
+	}
+	encoded, err := encoding.ReplaceUnsupported(enc.NewEncoder()).Bytes(snippet)
+	if err != nil {
+		return snippet
+	}
+	return encoded
+}
+
+var documentTags = [][]byte{[]byte("<!doctype html"), []byte("<html"), []byte("<body")}
+
+// Returns true if the HTML is a whole document, not a fragment.
+func hasDocumentStructure(body []byte) bool {
+	lower := bytes.ToLower(body)
+	for _, tag := range documentTags {
+		if bytes.Contains(lower, tag) {
+			return true
+		}
+	}
+	return false
+}
+
 // ============================================================
 // greload (Manual Code Injection)
 //
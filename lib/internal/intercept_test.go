@@ -9,6 +9,10 @@ import (
 
 	"github.com/yamavol/greload/lib/internal"
 	"github.com/yamavol/greload/test/harness"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
 )
 
 // ==================================================
@@ -34,6 +38,130 @@ func Test_Middleware(t *testing.T) {
 	harness.IsFalse(t, hasContentLength, "has content length")
 }
 
+// ==================================================
+// ResponseModifier Test
+// ==================================================
+func Test_ResponseModifierSkipsFragments(t *testing.T) {
+	modify := internal.ResponseModifier(internal.ClientOptions{Port: 9999})
+
+	injected := func(html string, header ...string) bool {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"text/html"}},
+			Body:       io.NopCloser(strings.NewReader(html)),
+			Request:    req,
+		}
+		harness.IsNil(t, modify(resp), "")
+		body, _ := io.ReadAll(resp.Body)
+		return strings.Contains(string(body), "<script>")
+	}
+
+	page := "<!DOCTYPE html><html><body>page</body></html>"
+	harness.IsTrue(t, injected(page), "page is injected")
+	harness.IsTrue(t, injected(page, "Sec-Fetch-Dest", "document"), "navigation is injected")
+	harness.IsTrue(t, injected(page, "Sec-Fetch-Dest", "iframe"), "iframe is injected")
+	harness.IsFalse(t, injected(page, "Sec-Fetch-Dest", "empty"), "fetch() is not injected")
+	harness.IsFalse(t, injected(page, "HX-Request", "true"), "htmx request is not injected")
+	harness.IsFalse(t, injected(page, "X-Requested-With", "XMLHttpRequest"), "XHR is not injected")
+	harness.IsFalse(t, injected("<div>fragment</div>"), "fragment is not injected")
+	harness.IsTrue(t, injected("<BODY>upper case</BODY>"), "tags are case insensitive")
+}
+
+func Test_ResponseModifierEncoding(t *testing.T) {
+	modify := internal.ResponseModifier(internal.ClientOptions{Port: 9999})
+
+	// returns the injected body decoded to UTF-8
+	inject := func(enc encoding.Encoding, html string, contentType string) string {
+		body, _ := enc.NewEncoder().Bytes([]byte(html))
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {contentType}},
+			Body:       io.NopCloser(strings.NewReader(string(body))),
+		}
+		harness.IsNil(t, modify(resp), "")
+		injected, _ := io.ReadAll(resp.Body)
+		decoded, err := enc.NewDecoder().Bytes(injected)
+		harness.IsNil(t, err, "")
+		return string(decoded)
+	}
+
+	page := "<!DOCTYPE html><html><head><meta charset=\"shift_jis\"></head><body>ページ</body></html>"
+	out := inject(japanese.ShiftJIS, page, "text/html")
+	harness.IsTrue(t, strings.HasPrefix(out, page), "shift_jis page is kept")
+	harness.IsTrue(t, strings.Contains(out, "new WebSocket"), "shift_jis script is readable")
+
+	page = "<html><body>café</body></html>"
+	out = inject(charmap.ISO8859_1, page, "text/html; charset=iso-8859-1")
+	harness.IsTrue(t, strings.HasPrefix(out, page), "latin1 page is kept")
+	harness.IsTrue(t, strings.Contains(out, "new WebSocket"), "latin1 script is readable")
+
+	utf16 := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM)
+	page = "<html><body>page</body></html>"
+	out = inject(utf16, page, "text/html")
+	harness.IsTrue(t, strings.HasPrefix(out, page), "utf-16 page is kept")
+	harness.IsTrue(t, strings.Contains(out, "new WebSocket"), "utf-16 script is readable")
+	harness.IsFalse(t, strings.Contains(out, "\uFEFF"), "no BOM in the middle of the page")
+}
+
+// returns the body after modify, and whether the script was injected
+func modifyBody(t *testing.T, modify func(*http.Response) error, method string, contentType string, html string) (string, bool) {
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(html)),
+		Request:    httptest.NewRequest(method, "http://example.com", nil),
+	}
+	if contentType != "" {
+		resp.Header.Set("Content-Type", contentType)
+	}
+	harness.IsNil(t, modify(resp), "")
+	body, _ := io.ReadAll(resp.Body)
+	return string(body), strings.Contains(string(body), "new WebSocket")
+}
+
+func Test_ResponseModifierSniff(t *testing.T) {
+	page := "<!DOCTYPE html><html><body>page</body></html>"
+
+	modify := internal.ResponseModifier(internal.ClientOptions{Port: 9999})
+	_, injected := modifyBody(t, modify, "GET", "", page)
+	harness.IsFalse(t, injected, "no sniffing by default")
+
+	modify = internal.ResponseModifier(internal.ClientOptions{Port: 9999, Sniff: true})
+	_, injected = modifyBody(t, modify, "GET", "", page)
+	harness.IsTrue(t, injected, "sniffed HTML is injected")
+	_, injected = modifyBody(t, modify, "GET", "", "plain text")
+	harness.IsFalse(t, injected, "sniffed text is not injected")
+	_, injected = modifyBody(t, modify, "GET", "text/plain", page)
+	harness.IsFalse(t, injected, "Content-Type is trusted when present")
+	_, injected = modifyBody(t, modify, "GET", "Text/HTML; charset=utf-8", page)
+	harness.IsTrue(t, injected, "media type is case insensitive")
+}
+
+func Test_ResponseModifierXhtml(t *testing.T) {
+	modify := internal.ResponseModifier(internal.ClientOptions{Port: 9999})
+
+	page := `<?xml version="1.0"?><html xmlns="http://www.w3.org/1999/xhtml"><body>page</body></html>`
+	body, injected := modifyBody(t, modify, "GET", "application/xhtml+xml", page)
+	harness.IsTrue(t, injected, "xhtml is injected")
+	harness.IsTrue(t, strings.HasSuffix(body, "</script>\n</body></html>"), "script is inside the body")
+	harness.IsTrue(t, strings.Contains(body, "<![CDATA["), "script is CDATA")
+}
+
+func Test_ForcedResponseModifier(t *testing.T) {
+	modify := internal.ForcedResponseModifier(internal.ClientOptions{Port: 9999})
+
+	body, injected := modifyBody(t, modify, "GET", "text/plain", "<div>fragment</div>")
+	harness.IsTrue(t, injected, "any content type is injected")
+	harness.IsTrue(t, strings.HasPrefix(body, "<div>fragment</div>"), "")
+
+	_, injected = modifyBody(t, modify, "HEAD", "text/plain", "")
+	harness.IsFalse(t, injected, "responses without body are untouched")
+}
+
 // ==================================================
 // Other tests
 // ==================================================
@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"fmt"
+	"html"
+)
+
+// ============================================================
+// HTML pages served by greload itself
+// ============================================================
+
+const buildingHtml = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="2">
+<title>Building...</title>
+<style>
+body { font: 16px/1.5 sans-serif; color: #333; margin: 0; display: flex;
+       align-items: center; justify-content: center; height: 100vh; }
+</style>
+</head>
+<body>
+<p>Building... the page reloads when the build completes.</p>
+</body>
+</html>
+`
+
+// BuildingPage returns the page shown while the startup command runs.
+func BuildingPage(opts ClientOptions) []byte {
+	return append([]byte(buildingHtml), ReloadScript(opts)...)
+}
+
+const unavailableHtml = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Upstream unavailable</title>
+<style>
+body { font: 16px/1.5 sans-serif; color: #333; max-width: 40em; margin: 15vh auto; padding: 0 1em; }
+h1 { font-size: 1.4em; }
+pre { background: #f4f4f4; padding: 1em; white-space: pre-wrap; word-break: break-all; }
+</style>
+</head>
+<body>
+<h1>%s: %s</h1>
+<p>greload could not get a response from the upstream server.
+The page reloads when the server responds again.</p>
+<pre>%s</pre>
+</body>
+</html>
+`
+
+// UnavailablePage returns the page shown when the upstream does not respond.
+func UnavailablePage(opts ClientOptions, host string, reason string, err error) []byte {
+	page := fmt.Sprintf(unavailableHtml,
+		html.EscapeString(host),
+		html.EscapeString(reason),
+		html.EscapeString(err.Error()),
+	)
+	return append([]byte(page), ReloadScript(opts)...)
+}
@@ -0,0 +1,14 @@
+//go:build !(windows || plan9)
+
+package internal
+
+import (
+	"errors"
+	"syscall"
+)
+
+// IsConnRefused returns true if the connection was refused, that is nothing
+// listens on the port.
+func IsConnRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
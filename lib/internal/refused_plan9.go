@@ -0,0 +1,9 @@
+package internal
+
+import "strings"
+
+// IsConnRefused returns true if the connection was refused, that is nothing
+// listens on the port. Plan 9 reports the errors as strings.
+func IsConnRefused(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "connection refused")
+}
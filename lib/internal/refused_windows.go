@@ -0,0 +1,13 @@
+package internal
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// IsConnRefused returns true if the connection was refused, that is nothing
+// listens on the port. Windows refuses with its own socket error.
+func IsConnRefused(err error) bool {
+	return errors.Is(err, windows.WSAECONNREFUSED)
+}
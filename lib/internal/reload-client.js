@@ -1,12 +1,16 @@
 (function refresh () {
   const verboseLogging = false;
+  const options = /*options*/{};
 
+  const secure = window.location.protocol === "https:";
   let socketUrl = window.location.origin;
-  if (!window.location.origin.match(/:[0-9]+/)) {
-    socketUrl = window.location.origin + ":80";
+  if (!window.location.origin.match(/:[0-9]+$/)) {
+    socketUrl = window.location.origin + (secure ? ":443" : ":80");
   }
 
-  socketUrl = socketUrl.replace(/^https?:\/\/(.+):(\d+)/, "ws://$1:9765"); 
+  socketUrl = socketUrl.replace(/^https?:\/\/(.+):(\d+)/, (secure ? "wss" : "ws") + "://$1:9765");
+  // tells the page to the server, listed by "greload clients"
+  socketUrl += "/?page=" + encodeURIComponent(window.location.pathname + window.location.search);
   let socket;
 
   function dprint(...msg) {
@@ -18,6 +22,15 @@
 
   dprint("reload script loaded");
 
+  if (options.unregisterServiceWorkers && "serviceWorker" in navigator) {
+    navigator.serviceWorker.getRegistrations().then(function (registrations) {
+      for (const registration of registrations) {
+        registration.unregister();
+        dprint("service worker unregistered", registration.scope);
+      }
+    });
+  }
+
   if (!("WebSocket" in window)) {
     throw new Error("WebSocket not supported in this browser");
   }
@@ -33,13 +46,63 @@
   });
 
   const socketOnMessage = function (msg) {
-    if (msg.data === "reload") {
-      dprint("reloading...");
-      socket.close();
-      window.location.reload();
+    let data;
+    try {
+      data = JSON.parse(msg.data);
+    } catch (err) {
+      eprint("invalid message", msg.data);
+      return;
+    }
+    switch (data.type) {
+      case "reload":
+        if (!matchesPaths(data.paths, window.location.pathname)) {
+          dprint("reload is not for this page");
+          break;
+        }
+        dprint("reloading...");
+        socket.close();
+        window.location.reload();
+        break;
+      case "css":
+        dprint("reloading stylesheets...");
+        reloadStylesheets(data.paths);
+        break;
+      case "build-error":
+        console.warn("[greload] build failed, page was not reloaded");
+        showOverlay(data.output || "");
+        break;
+      default:
+        dprint("unknown message", data.type);
     }
   };
 
+  // true if no paths are given, or the path matches one of the globs
+  function matchesPaths(paths, path) {
+    if (!paths || paths.length === 0) return true;
+    return paths.some(function (glob) {
+      const pattern = glob.split("*").map(function (part) {
+        return part.replace(/[.+?^${}()|[\]\\]/g, "\\$&");
+      }).join(".*");
+      return new RegExp("^" + pattern + "$").test(path);
+    });
+  }
+
+  // Replaces the stylesheet links with cache-busted copies, keeping the old
+  // ones until the new ones are loaded to avoid a flash of unstyled content.
+  function reloadStylesheets(paths) {
+    const links = document.querySelectorAll("link[rel=stylesheet][href]");
+    for (const link of links) {
+      const url = new URL(link.href, window.location.href);
+      if (!matchesPaths(paths, url.pathname)) continue;
+      url.searchParams.set("greload", Date.now().toString());
+      const copy = link.cloneNode();
+      copy.href = url.href;
+      copy.addEventListener("load", function () { link.remove(); });
+      copy.addEventListener("error", function () { link.remove(); });
+      link.after(copy);
+    }
+  }
+
   const socketOnOpen = function (_msg) {
     dprint("ws connected");
 
@@ -62,6 +125,95 @@
     eprint(msg);
   };
 
+  // ANSI SGR color codes to CSS colors
+  const ansiColors = {
+    30: "#4d4d4d", 31: "#ff6b6b", 32: "#69db7c", 33: "#ffd43b",
+    34: "#74c0fc", 35: "#e599f7", 36: "#66d9e8", 37: "#e9ecef",
+    90: "#868e96", 91: "#ff8787", 92: "#8ce99a", 93: "#ffe066",
+    94: "#a5d8ff", 95: "#eebefa", 96: "#99e9f2", 97: "#ffffff",
+  };
+
+  function escapeHtml (text) {
+    return text.replace(/&/g, "&amp;").replace(/</g, "&lt;").replace(/>/g, "&gt;");
+  }
+
+  /** Convert text with ANSI escape sequences to HTML */
+  function ansiToHtml (text) {
+    let html = "";
+    let open = false;
+    let bold = false;
+    let color = null;
+    const re = /\x1b\[([0-9;]*)([A-Za-z])/g;
+    let last = 0;
+    let m;
+    while ((m = re.exec(text)) !== null) {
+      html += escapeHtml(text.slice(last, m.index));
+      last = re.lastIndex;
+      if (m[2] !== "m") {
+        continue; // drop cursor movements and other sequences
+      }
+      const codes = m[1] === "" ? [0] : m[1].split(";").map(Number);
+      for (const code of codes) {
+        if (code === 0) { bold = false; color = null; }
+        else if (code === 1) { bold = true; }
+        else if (code === 22) { bold = false; }
+        else if (code === 39) { color = null; }
+        else if (ansiColors[code]) { color = ansiColors[code]; }
+      }
+      if (open) {
+        html += "</span>";
+        open = false;
+      }
+      if (bold || color) {
+        html += "<span style=\"" + (bold ? "font-weight:bold;" : "") + (color ? "color:" + color + ";" : "") + "\">";
+        open = true;
+      }
+    }
+    html += escapeHtml(text.slice(last));
+    if (open) {
+      html += "</span>";
+    }
+    return html;
+  }
+
+  const overlayId = "__greload_overlay";
+
+  /** Show build output on top of the page */
+  function showOverlay (output) {
+    hideOverlay();
+    const overlay = document.createElement("div");
+    overlay.id = overlayId;
+    overlay.style.cssText = "position:fixed;inset:0;z-index:2147483647;overflow:auto;" +
+      "background:rgba(0,0,0,0.85);color:#e9ecef;font:13px/1.5 monospace;padding:24px;";
+
+    const close = document.createElement("button");
+    close.textContent = "\u00d7";
+    close.title = "dismiss";
+    close.style.cssText = "position:absolute;top:12px;right:16px;background:none;border:none;" +
+      "color:inherit;font-size:24px;cursor:pointer;";
+    close.addEventListener("click", hideOverlay);
+
+    const title = document.createElement("div");
+    title.textContent = "Build failed";
+    title.style.cssText = "color:#ff6b6b;font-size:16px;font-weight:bold;margin-bottom:12px;";
+
+    const pre = document.createElement("pre");
+    pre.style.cssText = "margin:0;white-space:pre-wrap;word-break:break-word;";
+    pre.innerHTML = ansiToHtml(output);
+
+    overlay.appendChild(close);
+    overlay.appendChild(title);
+    overlay.appendChild(pre);
+    document.body.appendChild(overlay);
+  }
+
+  function hideOverlay () {
+    const overlay = document.getElementById(overlayId);
+    if (overlay) {
+      overlay.remove();
+    }
+  }
+
   /** Start WebSocket connection */
   function websocketStart () {
     dprint("starting ws connection...");
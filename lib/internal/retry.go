@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/yamavol/greload/log"
+)
+
+const (
+	retryMinBackoff = 50 * time.Millisecond
+	retryMaxBackoff = time.Second
+)
+
+// RetryTransport retries idempotent requests refused by the upstream, so
+// that page loads wait for a restarting server instead of failing.
+type RetryTransport struct {
+	Transport http.RoundTripper
+	Timeout   time.Duration // give up after this duration, no retry if 0
+}
+
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Timeout <= 0 || !isRetryable(req) {
+		return t.Transport.RoundTrip(req)
+	}
+
+	deadline := time.Now().Add(t.Timeout)
+	backoff := retryMinBackoff
+	for {
+		resp, err := t.Transport.RoundTrip(req)
+		if err == nil || !IsConnRefused(err) || time.Now().Add(backoff).After(deadline) {
+			return resp, err
+		}
+		log.Debugf("[proxy] connection refused, retry in %v: %s", backoff, req.URL)
+
+		select {
+		case <-req.Context().Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, retryMaxBackoff)
+
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// Returns true if the request can be sent again safely.
+func isRetryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+	// the body must be rewindable to send it again
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
@@ -0,0 +1,72 @@
+package internal_test
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yamavol/greload/lib/internal"
+	"github.com/yamavol/greload/test/harness"
+)
+
+// Returns an address where nothing listens (yet)
+func unusedAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	harness.IsNil(t, err, "")
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func Test_IsConnRefused(t *testing.T) {
+	_, err := net.Dial("tcp", unusedAddr(t))
+	harness.IsTrue(t, internal.IsConnRefused(err), "nothing listens")
+	harness.IsFalse(t, internal.IsConnRefused(errors.New("unexpected EOF")), "")
+	harness.IsFalse(t, internal.IsConnRefused(nil), "")
+}
+
+func Test_RetryTransportWaitsForUpstream(t *testing.T) {
+	addr := unusedAddr(t)
+
+	// start the upstream a bit later
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return
+		}
+		upstream.Listener = l
+		upstream.Start()
+	}()
+	defer upstream.Close()
+
+	rt := &internal.RetryTransport{Transport: &http.Transport{}, Timeout: 5 * time.Second}
+	req := httptest.NewRequest("GET", "http://"+addr+"/", nil)
+	req.RequestURI = ""
+	resp, err := rt.RoundTrip(req)
+	harness.IsNil(t, err, "GET should wait for the upstream")
+	if err == nil {
+		harness.IsEqual(t, resp.StatusCode, 200, "")
+		resp.Body.Close()
+	}
+}
+
+func Test_RetryTransportSkipsPost(t *testing.T) {
+	addr := unusedAddr(t)
+
+	rt := &internal.RetryTransport{Transport: &http.Transport{}, Timeout: 5 * time.Second}
+	req := httptest.NewRequest("POST", "http://"+addr+"/", strings.NewReader("data"))
+	req.RequestURI = ""
+
+	start := time.Now()
+	_, err := rt.RoundTrip(req)
+	harness.IsNotNil(t, err, "POST should fail")
+	harness.IsTrue(t, time.Since(start) < time.Second, "POST should not be retried")
+}
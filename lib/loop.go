@@ -0,0 +1,106 @@
+package lib
+
+// ============================================================
+// Reload loop detection
+// ============================================================
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// file events this soon after a command ends are from the command
+	cmdGracePeriod = 2 * defaultDebounceDuration
+
+	// more reloads than this within the window is considered a loop
+	loopWindow    = 10 * time.Second
+	loopThreshold = 15
+)
+
+// loopGuard filters the file events caused by the command itself, and
+// detects runaway reloads.
+type loopGuard struct {
+	mu      sync.Mutex
+	outputs []string // absolute paths written by the command
+	running int
+	ended   time.Time
+	reloads []time.Time
+}
+
+func newLoopGuard(outputs []string) *loopGuard {
+	abs := make([]string, 0, len(outputs))
+	for _, p := range outputs {
+		if a, err := filepath.Abs(p); err == nil {
+			abs = append(abs, a)
+		}
+	}
+	return &loopGuard{outputs: abs}
+}
+
+func (g *loopGuard) commandStarted() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.running++
+}
+
+func (g *loopGuard) commandEnded() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.running--
+	g.ended = time.Now()
+}
+
+// Returns true if the change should not trigger a reload.
+func (g *loopGuard) suppressed(path string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.running > 0 || time.Since(g.ended) < cmdGracePeriod {
+		return true
+	}
+	if len(g.outputs) == 0 {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	for _, out := range g.outputs {
+		if isSubpath(abs, out) {
+			return true
+		}
+	}
+	return false
+}
+
+// Records a reload, and returns true if reloads are happening too often.
+func (g *loopGuard) record(now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	recent := g.reloads[:0]
+	for _, t := range g.reloads {
+		if now.Sub(t) < loopWindow {
+			recent = append(recent, t)
+		}
+	}
+	g.reloads = append(recent, now)
+	return len(g.reloads) > loopThreshold
+}
+
+func (g *loopGuard) reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.reloads = nil
+}
+
+// Returns true if path is dir itself or is inside dir.
+func isSubpath(path string, dir string) bool {
+	if path == dir {
+		return true
+	}
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
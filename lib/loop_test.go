@@ -0,0 +1,37 @@
+package lib
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yamavol/greload/test/harness"
+)
+
+func Test_loopGuardSuppressed(t *testing.T) {
+	g := newLoopGuard([]string{"dist", "out/bundle.js"})
+
+	harness.IsFalse(t, g.suppressed("src/main.js"), "source change is not suppressed")
+	harness.IsTrue(t, g.suppressed("dist/main.js"), "file in output dir is suppressed")
+	harness.IsTrue(t, g.suppressed(filepath.Join(".", "out", "bundle.js")), "output file is suppressed")
+	harness.IsFalse(t, g.suppressed("distribution/main.js"), "prefix match is not a subpath")
+
+	g.commandStarted()
+	harness.IsTrue(t, g.suppressed("src/main.js"), "changes are suppressed while command runs")
+	g.commandEnded()
+	harness.IsTrue(t, g.suppressed("src/main.js"), "changes are suppressed right after command ends")
+}
+
+func Test_loopGuardRecord(t *testing.T) {
+	g := newLoopGuard(nil)
+	now := time.Now()
+
+	for i := 0; i < loopThreshold; i++ {
+		harness.IsFalse(t, g.record(now), "below threshold")
+	}
+	harness.IsTrue(t, g.record(now), "above threshold is a loop")
+	harness.IsFalse(t, g.record(now.Add(loopWindow)), "old reloads are forgotten")
+
+	g.reset()
+	harness.IsFalse(t, g.record(now), "reset clears the history")
+}
@@ -0,0 +1,89 @@
+package lib
+
+// ============================================================
+// Proxied response modifier
+// ============================================================
+
+import (
+	"net/http"
+
+	"github.com/yamavol/greload/lib/internal"
+)
+
+// For ReverseProxy.ModifyResponse. Rewrites the upstream URLs and cookies
+// back to the proxy, injects the reload script in HTML response, and then
+// applies the response header rules.
+func responseModifier(srv *ProxyServer) func(*http.Response) error {
+	inject := internal.ResponseModifier(srv.clientOptions())
+	forceInject := internal.ForcedResponseModifier(srv.clientOptions())
+
+	return func(resp *http.Response) error {
+		// path requested by the browser
+		path := resp.Request.URL.Path
+		if route, ok := routeFrom(resp.Request.Context()); ok {
+			path = route.strippedPrefix() + path
+		}
+
+		if route, ok := routeFrom(resp.Request.Context()); ok && route.Dir == "" {
+			cookies := srv.options.Cookies
+			if route.Cookies != nil {
+				cookies = *route.Cookies
+			}
+			rewriteCookies(resp, route.strippedPrefix(), cookies)
+		}
+		if m := newURLMapper(resp); m != nil {
+			rewriteHeaders(resp, m)
+			if srv.options.RewriteBody {
+				if err := rewriteBody(resp, m); err != nil {
+					return err
+				}
+			}
+		}
+		if srv.options.shouldForceInject(path) {
+			if err := forceInject(resp); err != nil {
+				return err
+			}
+		} else if srv.options.shouldInject(path) {
+			if err := inject(resp); err != nil {
+				return err
+			}
+		}
+
+		if srv.options.NoCache {
+			disableCache(resp.Header)
+		}
+
+		if len(srv.options.ResponseHeaders) > 0 {
+			contentType := resp.Header.Get("Content-Type")
+			for _, rule := range srv.options.ResponseHeaders {
+				if rule.matches(path, contentType) {
+					rule.apply(resp.Header)
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// Returns true if the reload script may be injected in the page at path.
+func (s *ServerOptions) shouldInject(path string) bool {
+	if matchesAny(s.NoInject, path) {
+		return false
+	}
+	return len(s.InjectOnly) == 0 || matchesAny(s.InjectOnly, path)
+}
+
+// Returns true if the reload script must be injected in the response at
+// path, whatever it looks like. --no-inject takes precedence.
+func (s *ServerOptions) shouldForceInject(path string) bool {
+	return matchesAny(s.ForceInject, path) && !matchesAny(s.NoInject, path)
+}
+
+func matchesAny(globs []string, path string) bool {
+	for _, glob := range globs {
+		if globMatch(glob, path) {
+			return true
+		}
+	}
+	return false
+}
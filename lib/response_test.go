@@ -0,0 +1,108 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yamavol/greload/test/harness"
+)
+
+func Test_shouldInject(t *testing.T) {
+	opt := NewServerOption()
+	harness.IsTrue(t, opt.shouldInject("/widget/frame.html"), "all pages by default")
+
+	opt.NoInject = []string{"/widget/*"}
+	harness.IsFalse(t, opt.shouldInject("/widget/frame.html"), "excluded path")
+	harness.IsTrue(t, opt.shouldInject("/index.html"), "")
+
+	opt.InjectOnly = []string{"/app/*", "/"}
+	harness.IsTrue(t, opt.shouldInject("/"), "")
+	harness.IsTrue(t, opt.shouldInject("/app/settings"), "")
+	harness.IsFalse(t, opt.shouldInject("/index.html"), "not included")
+}
+
+func Test_shouldForceInject(t *testing.T) {
+	opt := NewServerOption()
+	harness.IsFalse(t, opt.shouldForceInject("/legacy/page"), "none by default")
+
+	opt.ForceInject = []string{"/legacy/*"}
+	harness.IsTrue(t, opt.shouldForceInject("/legacy/page"), "")
+	harness.IsFalse(t, opt.shouldForceInject("/index.html"), "")
+
+	opt.NoInject = []string{"/legacy/raw"}
+	harness.IsFalse(t, opt.shouldForceInject("/legacy/raw"), "no-inject takes precedence")
+}
+
+// ==================================================
+// Injection against a local upstream
+// ==================================================
+
+const testPage = "<!DOCTYPE html><html><body>page</body></html>"
+
+func newInjectionProxy(t *testing.T) *httptest.Server {
+	upstream := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		switch r.URL.Path {
+		case "/nocontent":
+			w.WriteHeader(http.StatusNoContent)
+		case "/notmodified":
+			w.Header().Set("ETag", `"v1"`)
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			// serves HEAD and Range requests, and sets Accept-Ranges
+			http.ServeContent(w, r, "page.html", time.Time{}, strings.NewReader(testPage))
+		}
+	})
+
+	opt := NewServerOption()
+	opt.SetForwardHost(upstream.URL)
+	return newTestProxy(t, opt)
+}
+
+func Test_injectFullResponse(t *testing.T) {
+	proxy := newInjectionProxy(t)
+
+	resp, body := doRequest(t, "GET", proxy.URL+"/")
+	harness.IsEqual(t, resp.StatusCode, 200, "")
+	harness.IsTrue(t, strings.HasPrefix(body, testPage) && strings.Contains(body, "<script>"), "script is injected")
+	harness.IsEqual(t, resp.Header.Get("Content-Length"), strconv.Itoa(len(body)), "content length is updated")
+	harness.IsEqual(t, resp.Header.Get("Accept-Ranges"), "", "accept ranges is removed")
+}
+
+func Test_injectSkipsHead(t *testing.T) {
+	proxy := newInjectionProxy(t)
+
+	resp, body := doRequest(t, "HEAD", proxy.URL+"/")
+	harness.IsEqual(t, resp.StatusCode, 200, "")
+	harness.IsEqual(t, body, "", "")
+	harness.IsEqual(t, resp.Header.Get("Content-Length"), strconv.Itoa(len(testPage)), "content length of the upstream")
+	harness.IsEqual(t, resp.Header.Get("Accept-Ranges"), "bytes", "headers are untouched")
+}
+
+func Test_injectSkipsRange(t *testing.T) {
+	proxy := newInjectionProxy(t)
+
+	resp, body := doRequest(t, "GET", proxy.URL+"/", "Range", "bytes=0-8")
+	harness.IsEqual(t, resp.StatusCode, http.StatusPartialContent, "")
+	harness.IsEqual(t, body, testPage[:9], "partial body is untouched")
+	harness.IsEqual(t, resp.Header.Get("Content-Length"), "9", "")
+	harness.IsEqual(t, resp.Header.Get("Content-Range"), "bytes 0-8/"+strconv.Itoa(len(testPage)), "")
+}
+
+func Test_injectSkipsBodiless(t *testing.T) {
+	proxy := newInjectionProxy(t)
+
+	resp, body := doRequest(t, "GET", proxy.URL+"/nocontent")
+	harness.IsEqual(t, resp.StatusCode, http.StatusNoContent, "")
+	harness.IsEqual(t, body, "", "")
+	harness.IsEqual(t, resp.Header.Get("Content-Length"), "", "204 has no content length")
+
+	resp, body = doRequest(t, "GET", proxy.URL+"/notmodified")
+	harness.IsEqual(t, resp.StatusCode, http.StatusNotModified, "")
+	harness.IsEqual(t, body, "", "")
+	harness.IsEqual(t, resp.Header.Get("ETag"), `"v1"`, "")
+}
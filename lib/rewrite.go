@@ -0,0 +1,135 @@
+package lib
+
+// ============================================================
+// Rewrite upstream URLs to the proxy origin
+// ============================================================
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// urlMapper maps the URLs of an upstream to the proxy.
+type urlMapper struct {
+	upstreams []string // origins of the upstream, such as "http://localhost:4000"
+	proxy     string   // origin of the proxy, as seen by the browser
+	prefix    string   // route prefix added to the path, if stripped
+	re        *regexp.Regexp
+}
+
+// Returns the mapper for the response, or nil if nothing to rewrite.
+func newURLMapper(resp *http.Response) *urlMapper {
+	req := resp.Request
+	if req == nil || req.URL == nil {
+		return nil
+	}
+	route, ok := routeFrom(req.Context())
+	if !ok || route.Dir != "" {
+		return nil
+	}
+
+	proxyHost := req.Header.Get("X-Forwarded-Host")
+	proxyScheme := req.Header.Get("X-Forwarded-Proto")
+	if proxyHost == "" || proxyScheme == "" {
+		return nil
+	}
+
+	m := &urlMapper{
+		upstreams: upstreamOrigins(req.URL.Scheme, req.URL.Host),
+		proxy:     proxyScheme + "://" + proxyHost,
+		prefix:    route.strippedPrefix(),
+	}
+
+	quoted := make([]string, len(m.upstreams))
+	for i, origin := range m.upstreams {
+		quoted[i] = regexp.QuoteMeta(origin)
+	}
+	// the origin must not continue with more host or port characters
+	m.re = regexp.MustCompile(`(?i)(` + strings.Join(quoted, "|") + `)([^A-Za-z0-9.:\-]|$)`)
+	return m
+}
+
+// Returns the origins the upstream may use for itself. The port is
+// optional if it is the default port of the scheme.
+func upstreamOrigins(scheme string, host string) []string {
+	origins := []string{scheme + "://" + host}
+	hostname, port, err := net.SplitHostPort(host)
+	if err == nil && port == knownPort(scheme) {
+		if strings.Contains(hostname, ":") {
+			hostname = "[" + hostname + "]"
+		}
+		origins = append(origins, scheme+"://"+hostname)
+	}
+	return origins
+}
+
+// Rewrites an absolute URL of the upstream, or an absolute path, to the
+// proxy. Other URLs are returned unchanged.
+func (m *urlMapper) rewrite(u string) string {
+	if strings.HasPrefix(u, "/") && !strings.HasPrefix(u, "//") {
+		return m.prefix + u
+	}
+	loc := m.re.FindStringSubmatchIndex(u)
+	if loc == nil || loc[0] != 0 {
+		return u
+	}
+	return m.proxy + m.prefix + u[loc[3]:]
+}
+
+// Rewrites the upstream origins in the body text.
+func (m *urlMapper) rewriteText(body []byte) []byte {
+	return m.re.ReplaceAll(body, []byte(m.proxy+m.prefix+"$2"))
+}
+
+// Rewrites the URLs in the Location, Content-Location and Refresh headers.
+func rewriteHeaders(resp *http.Response, m *urlMapper) {
+	for _, key := range []string{"Location", "Content-Location"} {
+		if v := resp.Header.Get(key); v != "" {
+			resp.Header.Set(key, m.rewrite(v))
+		}
+	}
+	if v := resp.Header.Get("Refresh"); v != "" {
+		resp.Header.Set("Refresh", rewriteRefresh(v, m))
+	}
+}
+
+var refreshUrlRe = regexp.MustCompile(`(?i)(url\s*=\s*['"]?)([^'"]*)`)
+
+// Rewrites the URL in a Refresh value, such as "5; url=/login".
+func rewriteRefresh(v string, m *urlMapper) string {
+	loc := refreshUrlRe.FindStringSubmatchIndex(v)
+	if loc == nil {
+		return v
+	}
+	return v[:loc[4]] + m.rewrite(v[loc[4]:loc[5]]) + v[loc[5]:]
+}
+
+// Rewrites the absolute upstream URLs in HTML and CSS bodies.
+func rewriteBody(resp *http.Response, m *urlMapper) error {
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "text/html") && !strings.HasPrefix(contentType, "text/css") {
+		return nil
+	}
+	if enc := resp.Header.Get("Content-Encoding"); enc != "" && enc != "identity" {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err = resp.Body.Close(); err != nil {
+		return err
+	}
+
+	body = m.rewriteText(body)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
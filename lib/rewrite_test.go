@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/yamavol/greload/test/harness"
+)
+
+func Test_rewriteUpstreamURLs(t *testing.T) {
+	var upstreamURL string
+	upstream := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, upstreamURL+"/login?next=1", http.StatusFound)
+		case "/relative":
+			w.Header().Set("Refresh", "0; url=/login")
+			http.Redirect(w, r, "/login", http.StatusFound)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="` + upstreamURL + `/page">x</a><a href="` + upstreamURL + `0/other">y</a>`))
+		}
+	})
+	upstreamURL = upstream.URL
+
+	opt := NewServerOption()
+	opt.SetForwardHost(upstream.URL)
+	opt.AddRoute("/api=" + upstream.URL + ",strip")
+	opt.RewriteBody = true
+	proxy := newTestProxy(t, opt)
+
+	resp, _ := doRequest(t, "GET", proxy.URL+"/redirect")
+	harness.IsEqual(t, resp.Header.Get("Location"), proxy.URL+"/login?next=1", "absolute redirect goes to the proxy")
+
+	resp, _ = doRequest(t, "GET", proxy.URL+"/api/redirect")
+	harness.IsEqual(t, resp.Header.Get("Location"), proxy.URL+"/api/login?next=1", "stripped prefix is restored")
+
+	resp, _ = doRequest(t, "GET", proxy.URL+"/api/relative")
+	harness.IsEqual(t, resp.Header.Get("Location"), "/api/login", "absolute path gets the prefix")
+	harness.IsEqual(t, resp.Header.Get("Refresh"), "0; url=/api/login", "refresh url is rewritten")
+
+	_, body := doRequest(t, "GET", proxy.URL+"/")
+	harness.IsTrue(t, strings.Contains(body, `href="`+proxy.URL+`/page"`), "body url is rewritten")
+	harness.IsTrue(t, strings.Contains(body, `href="`+upstream.URL+`0/other"`), "other port is not rewritten")
+}
+
+func Test_upstreamOrigins(t *testing.T) {
+	origins := upstreamOrigins("http", "localhost:80")
+	harness.IsEqual(t, len(origins), 2, "default port is optional")
+	harness.IsEqual(t, origins[1], "http://localhost", "")
+
+	origins = upstreamOrigins("https", "localhost:8443")
+	harness.IsEqual(t, len(origins), 1, "")
+}
@@ -0,0 +1,221 @@
+package lib
+
+// ============================================================
+// Path-based routing
+// ============================================================
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Route forwards the requests under Prefix to Target, or serves them from
+// the local directory Dir.
+type Route struct {
+	Prefix      string
+	Target      *url.URL
+	Dir         string
+	StripPrefix bool           // remove Prefix from the path before forwarding
+	Cookies     *CookieRewrite // overrides ServerOptions.Cookies if set
+}
+
+// ParseRoute parses a route rule in "PREFIX=URL[,option...]" form, such as
+// "/api=localhost:8080,strip". The options are:
+//
+//	strip                 remove the prefix from the forwarded path
+//	cookie-domain=VALUE   replace the cookie Domain ("-" removes it)
+//	cookie-samesite=VALUE replace the cookie SameSite ("-" removes it)
+//	cookie-insecure       remove the cookie Secure attribute
+func ParseRoute(rule string) (Route, error) {
+	prefix, rest, ok := strings.Cut(rule, "=")
+	if !ok {
+		return Route{}, fmt.Errorf("invalid route %q: expected PREFIX=URL", rule)
+	}
+	prefix = strings.TrimSpace(prefix)
+	if !strings.HasPrefix(prefix, "/") {
+		return Route{}, fmt.Errorf("invalid route %q: prefix must start with /", rule)
+	}
+
+	fields := strings.Split(rest, ",")
+	target, err := parseHost(fields[0])
+	if err != nil {
+		return Route{}, fmt.Errorf("invalid route %q: %w", rule, err)
+	}
+
+	route := Route{Prefix: cleanPrefix(prefix), Target: target}
+	for _, opt := range fields[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(opt), "=")
+		if key == "strip" {
+			route.StripPrefix = true
+			continue
+		}
+		cookies := CookieRewrite{}
+		if route.Cookies != nil {
+			cookies = *route.Cookies
+		}
+		if !cookies.setOption(key, value) {
+			return Route{}, fmt.Errorf("invalid route %q: unknown option %q", rule, opt)
+		}
+		route.Cookies = &cookies
+	}
+	return route, nil
+}
+
+// AddRoute parses the rule and adds it to the routing table.
+func (s *ServerOptions) AddRoute(rule string) error {
+	route, err := ParseRoute(rule)
+	if err != nil {
+		return err
+	}
+	s.Routes = append(s.Routes, route)
+	return nil
+}
+
+// SetStaticDir serves the files in dir, instead of forwarding to Host.
+func (s *ServerOptions) SetStaticDir(dir string) error {
+	if err := checkDir(dir); err != nil {
+		return err
+	}
+	s.Routes = append(s.Routes, Route{Prefix: "/", Dir: dir})
+	return nil
+}
+
+// AddMount parses a mount rule in "PREFIX=DIR" form, such as
+// "/static=./dist", and serves the paths under PREFIX from DIR.
+func (s *ServerOptions) AddMount(rule string) error {
+	prefix, dir, ok := strings.Cut(rule, "=")
+	if !ok {
+		return fmt.Errorf("invalid mount %q: expected PREFIX=DIR", rule)
+	}
+	prefix = strings.TrimSpace(prefix)
+	if !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("invalid mount %q: prefix must start with /", rule)
+	}
+	if err := checkDir(dir); err != nil {
+		return fmt.Errorf("invalid mount %q: %w", rule, err)
+	}
+	s.Routes = append(s.Routes, Route{Prefix: cleanPrefix(prefix), Dir: dir, StripPrefix: true})
+	return nil
+}
+
+// LocalDirs returns the directories served by the routes.
+func (s *ServerOptions) LocalDirs() []string {
+	dirs := []string{}
+	for _, route := range s.Routes {
+		if route.Dir != "" {
+			dirs = append(dirs, route.Dir)
+		}
+	}
+	return dirs
+}
+
+// Returns the route for the path, using the longest matching prefix.
+// Host is the route for "/", unless a route for "/" is configured.
+func (s *ServerOptions) matchRoute(path string) (Route, bool) {
+	var best Route
+	found := false
+	for _, route := range s.Routes {
+		if hasPathPrefix(path, route.Prefix) && (!found || len(route.Prefix) > len(best.Prefix)) {
+			best = route
+			found = true
+		}
+	}
+	if !found && s.Host != nil && s.Host.Host != "" {
+		return Route{Prefix: "/", Target: s.Host}, true
+	}
+	return best, found
+}
+
+// Returns the prefix removed from the forwarded path, or "".
+func (r Route) strippedPrefix() string {
+	if !r.StripPrefix || r.Prefix == "/" {
+		return ""
+	}
+	return r.Prefix
+}
+
+// Returns the path forwarded to the upstream.
+func (r Route) upstreamPath(path string) string {
+	if !r.StripPrefix || r.Prefix == "/" {
+		return path
+	}
+	path = strings.TrimPrefix(path, r.Prefix)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// Returns the path of the upstream URL for the browser path, joined to the
+// path of Target as ReverseProxy.SetURL does.
+func (r Route) forwardPath(path string) string {
+	path = r.upstreamPath(path)
+	if r.Dir == "" && r.Target != nil && r.Target.Path != "" {
+		path = strings.TrimSuffix(r.Target.Path, "/") + path
+	}
+	return path
+}
+
+// Returns true if path is prefix itself or is under prefix.
+func hasPathPrefix(path string, prefix string) bool {
+	if prefix == "/" || path == prefix {
+		return true
+	}
+	return strings.HasPrefix(path, prefix+"/")
+}
+
+func checkDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("not a directory: %s", dir)
+	}
+	return nil
+}
+
+func cleanPrefix(prefix string) string {
+	if prefix == "/" {
+		return prefix
+	}
+	return strings.TrimRight(prefix, "/")
+}
+
+// ============================================================
+// Route in request context (Private)
+// ============================================================
+
+type routeKeyType struct{}
+
+var routeKey = routeKeyType{}
+
+func withRoute(ctx context.Context, route Route) context.Context {
+	return context.WithValue(ctx, routeKey, route)
+}
+
+func routeFrom(ctx context.Context) (Route, bool) {
+	route, ok := ctx.Value(routeKey).(Route)
+	return route, ok
+}
+
+// ============================================================
+// Route transport (Private)
+// ============================================================
+
+// routeTransport sends the request to the upstream, or serves it from the
+// directory of the route.
+type routeTransport struct {
+	upstream http.RoundTripper
+}
+
+func (t *routeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if route, ok := routeFrom(req.Context()); ok && route.Dir != "" {
+		return http.NewFileTransport(http.Dir(route.Dir)).RoundTrip(req)
+	}
+	return t.upstream.RoundTrip(req)
+}
@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yamavol/greload/test/harness"
+)
+
+func Test_ParseRoute(t *testing.T) {
+	r, err := ParseRoute("/api=localhost:8080")
+	harness.IsNil(t, err, "")
+	harness.IsEqual(t, r.Prefix, "/api", "")
+	harness.IsEqual(t, r.Target.String(), "http://localhost:8080", "")
+	harness.IsFalse(t, r.StripPrefix, "")
+
+	r, err = ParseRoute("/api/=https://127.0.0.1:8443,strip")
+	harness.IsNil(t, err, "")
+	harness.IsEqual(t, r.Prefix, "/api", "trailing slash is removed")
+	harness.IsEqual(t, r.Target.String(), "https://127.0.0.1:8443", "")
+	harness.IsTrue(t, r.StripPrefix, "")
+
+	_, err = ParseRoute("api=localhost:8080")
+	harness.IsNotNil(t, err, "prefix must start with /")
+	_, err = ParseRoute("/api")
+	harness.IsNotNil(t, err, "target is required")
+	_, err = ParseRoute("/api=localhost:8080,unknown")
+	harness.IsNotNil(t, err, "unknown option")
+}
+
+func Test_matchRoute(t *testing.T) {
+	opt := NewServerOption()
+	opt.SetForwardHost("localhost:3000")
+	opt.AddRoute("/api=localhost:8080")
+	opt.AddRoute("/api/v2=localhost:8082,strip")
+
+	r, ok := opt.matchRoute("/index.html")
+	harness.IsTrue(t, ok, "")
+	harness.IsEqual(t, r.Target.Host, "localhost:3000", "unmatched path goes to host")
+
+	r, _ = opt.matchRoute("/api")
+	harness.IsEqual(t, r.Target.Host, "localhost:8080", "")
+	r, _ = opt.matchRoute("/api/users")
+	harness.IsEqual(t, r.Target.Host, "localhost:8080", "")
+	r, _ = opt.matchRoute("/apix")
+	harness.IsEqual(t, r.Target.Host, "localhost:3000", "prefix matches whole segments")
+
+	r, _ = opt.matchRoute("/api/v2/users")
+	harness.IsEqual(t, r.Target.Host, "localhost:8082", "longest prefix wins")
+	harness.IsEqual(t, r.upstreamPath("/api/v2/users"), "/users", "")
+	harness.IsEqual(t, r.upstreamPath("/api/v2"), "/", "")
+
+	opt = NewServerOption()
+	opt.AddRoute("/api=localhost:8080")
+	_, ok = opt.matchRoute("/index.html")
+	harness.IsFalse(t, ok, "no route without host")
+}
+
+func Test_staticRoute(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html><body>hi</body></html>"), 0o644)
+	os.WriteFile(filepath.Join(dir, "style.css"), []byte("body{}"), 0o644)
+
+	opt := NewServerOption()
+	harness.IsNil(t, opt.SetStaticDir(dir), "")
+	proxy := newTestProxy(t, opt)
+
+	resp, body := doRequest(t, "GET", proxy.URL+"/")
+	harness.IsEqual(t, resp.StatusCode, 200, "")
+	harness.IsTrue(t, strings.HasPrefix(body, "<html><body>hi"), "index.html is served")
+	harness.IsTrue(t, strings.Contains(body, "<script>"), "reload script is injected")
+
+	resp, body = doRequest(t, "GET", proxy.URL+"/style.css")
+	harness.IsEqual(t, resp.Header.Get("Content-Type"), "text/css; charset=utf-8", "")
+	harness.IsEqual(t, body, "body{}", "")
+
+	harness.IsNotNil(t, opt.SetStaticDir(filepath.Join(dir, "style.css")), "file is not a directory")
+}
+
+func Test_AddMount(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "app.js"), []byte("local"), 0o644)
+
+	upstream := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("upstream " + r.URL.Path))
+	})
+
+	opt := NewServerOption()
+	opt.SetForwardHost(upstream.URL)
+	harness.IsNil(t, opt.AddMount("/static/="+dir), "")
+	harness.IsNotNil(t, opt.AddMount("/static"), "directory is required")
+	harness.IsNotNil(t, opt.AddMount("/static="+filepath.Join(dir, "none")), "directory must exist")
+	harness.IsEqual(t, len(opt.LocalDirs()), 1, "")
+
+	proxy := newTestProxy(t, opt)
+
+	_, body := doRequest(t, "GET", proxy.URL+"/static/app.js")
+	harness.IsEqual(t, body, "local", "mounted path is served from disk")
+	_, body = doRequest(t, "GET", proxy.URL+"/other/app.js")
+	harness.IsEqual(t, body, "upstream /other/app.js", "other paths are forwarded")
+}
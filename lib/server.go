@@ -7,11 +7,13 @@ package lib
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mattn/go-ieproxy"
@@ -27,33 +29,61 @@ const defaultDebounceDuration = 100 * time.Millisecond
 // and WebSocket connections.
 type ProxyServer struct {
 	options     ServerOptions
-	connections map[*websocket.Conn]struct{}
+	connections map[*websocket.Conn]Client
 	mu          sync.Mutex
 	reloadReq   notifier
+	buildError  string      // output of the last failed command, guarded by mu
+	building    atomic.Bool // true while the startup command runs
+	paused      atomic.Bool // true while automatic reloads are paused
+	guard       *loopGuard
+	probing     map[string]bool   // upstream origins being probed, guarded by mu
+	upstream    http.RoundTripper // transport to the upstreams, set by serverHandler
+	quit        chan struct{}     // closed by Stop
+	quitOnce    sync.Once
 }
 
 // Create a new instance of ProxyServer
 func NewServer(options *ServerOptions) *ProxyServer {
 	return &ProxyServer{
 		options:     *options,
-		connections: make(map[*websocket.Conn]struct{}),
+		connections: make(map[*websocket.Conn]Client),
 		reloadReq:   *newNotifier(),
+		guard:       newLoopGuard(options.Outputs),
+		probing:     make(map[string]bool),
+		quit:        make(chan struct{}),
 	}
 }
 
-// Start HTTP & WebSocket server, and listen to file change events
-func (srv *ProxyServer) Start() {
+// Start HTTP & WebSocket server, and listen to file change events.
+// Returns an error if the server fails, or if the startup command fails
+// in strict mode.
+func (srv *ProxyServer) Start() error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%v", srv.options.Port))
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	// the port is chosen by the system if 0, and the pages connect to it
+	srv.options.Port = listener.Addr().(*net.TCPAddr).Port
+
+	handler, err := serverHandler(srv)
+	if err != nil {
+		return err
+	}
+
 	server := http.Server{
-		Addr:    fmt.Sprintf("127.0.0.1:%v", srv.options.Port),
-		Handler: http.HandlerFunc(serverHandler(srv)),
+		Handler: http.HandlerFunc(handler),
 	}
 
 	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
+	signal.Notify(interrupt, shutdownSignals...)
 	debounced, _ := internal.NewDebouncer(defaultDebounceDuration)
 
 	go func() {
-		<-interrupt
+		select {
+		case <-interrupt:
+		case <-srv.quit:
+		}
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 		server.Shutdown(ctx)
@@ -65,32 +95,174 @@ func (srv *ProxyServer) Start() {
 		}
 	}()
 
-	log.Info("reload server is running on port", srv.options.Port)
-	log.Info("redirecting access to", srv.options.Host.String())
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Error("Error:", err)
+	scheme := "http"
+	if srv.options.servesHTTPS() {
+		config, err := srv.options.serverTLSConfig()
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = config
+		scheme = "https"
+	}
+
+	startupErr := make(chan error, 1)
+
+	if srv.options.runsCmdAtStart() {
+		if srv.options.BuildingPage {
+			// serve the building page while the command runs
+			srv.building.Store(true)
+			go func() {
+				err := srv.startupBuild()
+				if err != nil && srv.options.Strict {
+					startupErr <- err
+					server.Close()
+				}
+			}()
+		} else if err := srv.startupBuild(); err != nil && srv.options.Strict {
+			return err
+		}
+	}
+
+	log.Infof("reload server is running on %s://127.0.0.1:%v", scheme, srv.options.Port)
+	for _, route := range srv.options.Routes {
+		if route.Dir != "" {
+			log.Infof("serving %s from %s", route.Prefix, route.Dir)
+		} else {
+			log.Infof("redirecting access to %s to %s", route.Prefix, route.Target)
+		}
+	}
+	if srv.options.Host != nil && srv.options.Host.Host != "" {
+		log.Info("redirecting access to", srv.options.Host.String())
+	}
+
+	removeState, err := writeState(InstanceState{
+		PID:    os.Getpid(),
+		Port:   srv.options.Port,
+		Scheme: scheme,
+		Token:  srv.options.Token,
+	})
+	if err != nil {
+		log.Warnf("failed to write state file: %v", err)
+	} else {
+		defer removeState()
+	}
+
+	if server.TLSConfig != nil {
+		err = server.ServeTLS(listener, "", "")
+	} else {
+		err = server.Serve(listener)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	select {
+	case err := <-startupErr:
+		return err
+	default:
+		return nil
 	}
 }
 
+// Stop shuts down the server gracefully, and Start returns.
+func (srv *ProxyServer) Stop() {
+	srv.quitOnce.Do(func() { close(srv.quit) })
+}
+
 // Sends reload request to the connected websocket clients.
 func (srv *ProxyServer) TriggerReload() {
 	srv.reloadReq.Notify()
 }
 
-func serverHandler(srv *ProxyServer) func(w http.ResponseWriter, r *http.Request) {
+// Pause stops reloading on file changes. TriggerReload still works.
+func (srv *ProxyServer) Pause() {
+	if !srv.paused.Swap(true) {
+		log.Info("automatic reload paused")
+	}
+}
+
+// Resume restarts reloading on file changes.
+func (srv *ProxyServer) Resume() {
+	srv.guard.reset()
+	if srv.paused.Swap(false) {
+		log.Info("automatic reload resumed")
+	}
+}
+
+// Paused reports whether automatic reloads are paused.
+func (srv *ProxyServer) Paused() bool {
+	return srv.paused.Load()
+}
+
+// Called by the file watcher on change.
+func (srv *ProxyServer) notifyChange(path string) {
+	if srv.paused.Load() {
+		log.Debug("[fs]", "paused, ignored", path)
+		return
+	}
+	if srv.guard.suppressed(path) {
+		log.Debug("[fs]", "command output, ignored", path)
+		return
+	}
+	srv.TriggerReload()
+}
+
+func serverHandler(srv *ProxyServer) (func(w http.ResponseWriter, r *http.Request), error) {
+
+	upstreamTLS, err := srv.options.upstreamTLSConfig()
+	if err != nil {
+		return nil, err
+	}
 
 	// reverse proxy server config
 	rp := &httputil.ReverseProxy{}
 
-	rp.ModifyResponse = internal.ResponseModifier(srv.options.Port)
-	rp.Transport = &http.Transport{
+	rp.ModifyResponse = responseModifier(srv)
+	rp.ErrorHandler = upstreamErrorHandler(srv)
+	srv.upstream = &http.Transport{
 		// use http_proxy (env) if set, otherwise use system proxy
 		Proxy:             ieproxy.GetProxyFunc(),
 		DisableKeepAlives: true,
+		TLSClientConfig:   upstreamTLS,
+	}
+	rp.Transport = &routeTransport{
+		upstream: &internal.RetryTransport{
+			Transport: srv.upstream,
+			Timeout:   srv.options.UpstreamWait,
+		},
+	}
+	if srv.options.Fallback != "" {
+		rp.Transport = &fallbackTransport{transport: rp.Transport, page: srv.options.Fallback}
 	}
 	rp.Rewrite = func(pr *httputil.ProxyRequest) {
+		// the handler only forwards the requests with a route
+		route, _ := srv.options.matchRoute(pr.In.URL.Path)
+		if route.StripPrefix {
+			pr.Out.URL.Path = route.upstreamPath(pr.In.URL.Path)
+			pr.Out.URL.RawPath = ""
+		}
+		pr.Out = pr.Out.WithContext(withRoute(pr.Out.Context(), route))
 		pr.SetXForwarded()
-		pr.SetURL(srv.options.Host)
+		if srv.options.NoCache {
+			// always get the full response, never 304
+			removeConditionalHeaders(pr.Out)
+		}
+		if srv.options.RewriteBody {
+			// let the transport decompress the body to rewrite
+			pr.Out.Header.Del("Accept-Encoding")
+		}
+		if route.Dir != "" {
+			// served by routeTransport
+			pr.Out.URL.Scheme = "file"
+			pr.Out.URL.Host = ""
+			pr.Out.Host = ""
+		} else {
+			pr.SetURL(route.Target)
+			pr.Out.Host = srv.options.upstreamHostHeader(pr.In)
+		}
+		for _, rule := range srv.options.RequestHeaders {
+			rule.apply(pr.Out.Header)
+		}
 	}
 
 	// websocket server config
@@ -98,12 +270,34 @@ func serverHandler(srv *ProxyServer) func(w http.ResponseWriter, r *http.Request
 		srv.websockHandler(conn)
 	})
 
+	buildingPage := internal.BuildingPage(srv.clientOptions())
+	control := controlHandler(srv)
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Header.Get("Upgrade") == "websocket" {
 			ws.ServeHTTP(w, r)
+		} else if isControlPath(r.URL.Path) {
+			control.ServeHTTP(w, r)
+		} else if srv.building.Load() {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write(buildingPage)
+		} else if _, ok := srv.options.matchRoute(r.URL.Path); !ok {
+			http.NotFound(w, r)
 		} else {
 			rp.ServeHTTP(w, r)
 		}
+	}, nil
+}
+
+// Returns the options of the injected reload script.
+func (srv *ProxyServer) clientOptions() internal.ClientOptions {
+	return internal.ClientOptions{
+		Port:                     srv.options.Port,
+		UnregisterServiceWorkers: srv.options.UnregisterServiceWorkers,
+		Sniff:                    srv.options.Sniff,
 	}
 }
 
@@ -111,7 +305,11 @@ func (ws *ProxyServer) websockHandler(conn *websocket.Conn) {
 	defer conn.Close()
 
 	ws.mu.Lock()
-	ws.connections[conn] = struct{}{}
+	ws.connections[conn] = newClient(conn.Request())
+	if ws.buildError != "" {
+		// show the error to the pages opened after the build failed
+		websocket.JSON.Send(conn, message{Type: msgBuildError, Output: ws.buildError})
+	}
 	ws.mu.Unlock()
 
 	log.Debug("[ws] Client connected")
@@ -131,15 +329,20 @@ func (ws *ProxyServer) websockHandler(conn *websocket.Conn) {
 
 func (srv *ProxyServer) handleReload() {
 	var wg sync.WaitGroup
+	var cmdErr error
+	var buildError string
 
-	if srv.options.Cmd != "" {
+	// only the command can cause a loop, editors may save very often
+	if !srv.options.Cmd.IsEmpty() && srv.guard.record(time.Now()) {
+		srv.pauseForLoop()
+		return
+	}
+
+	if !srv.options.Cmd.IsEmpty() {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			log.Debugf("[cmd] exec: %s", srv.options.Cmd)
-			if err := internal.ExecuteCommand(srv.options.Cmd); err != nil {
-				log.Errorf("[cmd] Error: %v", err)
-			}
+			buildError, cmdErr = srv.runCommand()
 		}()
 	}
 
@@ -156,11 +359,79 @@ func (srv *ProxyServer) handleReload() {
 	// Wait all goroutines to end
 	wg.Wait()
 
-	// Send message to all connected clients
+	srv.notifyBuildResult(buildError, cmdErr)
+}
+
+// Pauses automatic reloads on a reload loop, and resumes them once the files
+// stop changing.
+func (srv *ProxyServer) pauseForLoop() {
+	log.Warnf("[loop] reload loop detected, the command seems to change the watched files")
+	log.Warnf("[loop] automatic reload paused, resume with POST %sresume", ControlPrefix)
+	if srv.options.OnReloadLoop != nil {
+		srv.options.OnReloadLoop()
+	}
+	srv.Pause()
+}
+
+// Runs the command once before serving pages.
+func (srv *ProxyServer) startupBuild() error {
+	log.Info("[cmd] running startup command")
+	buildError, err := srv.runCommand()
+	if srv.building.Swap(false) {
+		// release the clients waiting on the building page
+		srv.notifyBuildResult(buildError, err)
+	}
+	if err != nil {
+		return fmt.Errorf("startup command failed: %w", err)
+	}
+	return nil
+}
+
+// Runs the command, and keeps the error text for the reload clients.
+func (srv *ProxyServer) runCommand() (string, error) {
+	log.Debugf("[cmd] exec: %s", srv.options.Cmd)
+	srv.guard.commandStarted()
+	output, err := internal.ExecuteCommand(srv.options.Cmd)
+	srv.guard.commandEnded()
+
+	buildError := ""
+	if err != nil {
+		log.Errorf("[cmd] Error: %v", err)
+		buildError = buildErrorText(output, err)
+	}
+
+	srv.mu.Lock()
+	srv.buildError = buildError
+	srv.mu.Unlock()
+	return buildError, err
+}
+
+// Reloads the clients, or shows them the error if the build failed.
+func (srv *ProxyServer) notifyBuildResult(buildError string, err error) {
+	// A failed build leaves the page as it is, unless told otherwise
+	if err != nil && !srv.options.ReloadOnError {
+		log.Info("[cmd] build failed, reload skipped")
+		srv.broadcast(message{Type: msgBuildError, Output: buildError})
+		return
+	}
+
+	srv.broadcast(message{Type: msgReload})
+}
+
+// Returns the text shown in the browser overlay
+func buildErrorText(output string, err error) string {
+	if output == "" || output[len(output)-1] != '\n' {
+		output += "\n"
+	}
+	return output + err.Error()
+}
+
+// Send message to all connected clients
+func (srv *ProxyServer) broadcast(msg message) {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 	for conn := range srv.connections {
-		err := websocket.Message.Send(conn, "reload")
+		err := websocket.JSON.Send(conn, msg)
 		if err != nil {
 			delete(srv.connections, conn)
 			log.Errorf("Error broadcasting message to client: %v", err)
@@ -172,6 +443,23 @@ func (srv *ProxyServer) adjustedDelayTime() time.Duration {
 	return max(0, srv.options.Delay-defaultDebounceDuration)
 }
 
+// ============================================================
+// websocket message (Private)
+// ============================================================
+
+const (
+	msgReload     = "reload"
+	msgCSS        = "css"
+	msgBuildError = "build-error"
+)
+
+// message is the payload sent to the reload client.
+type message struct {
+	Type   string   `json:"type"`
+	Output string   `json:"output,omitempty"`
+	Paths  []string `json:"paths,omitempty"` // globs of pages or stylesheets to reload
+}
+
 // ============================================================
 // notifier (Private)
 // ============================================================
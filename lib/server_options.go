@@ -8,16 +8,107 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"os"
 	"regexp"
 	"strings"
 	"time"
+
+	"github.com/yamavol/greload/lib/internal"
 )
 
+// Command is the command executed on change. It runs Args directly if set,
+// otherwise it runs Line with the shell (sh -c, or cmd /C on Windows).
+type Command = internal.Command
+
 type ServerOptions struct {
+	// Port to listen on, chosen by the system if 0. Start sets the chosen
+	// port.
 	Port  int
 	Host  *url.URL
 	Delay time.Duration
-	Cmd   string
+	Cmd   Command
+
+	// Forward the matching paths to other upstreams. Host is the upstream
+	// of the paths that match no route.
+	Routes []Route
+
+	// Reload the page even if Cmd exits with an error
+	ReloadOnError bool
+
+	// Run Cmd once before serving pages
+	CmdAtStart bool
+
+	// Serve a building page while the startup command runs, instead of
+	// waiting for it before serving. Implies CmdAtStart.
+	BuildingPage bool
+
+	// Fail to start if the startup command fails. Implies CmdAtStart.
+	Strict bool
+
+	// Paths written by Cmd. Changes in these paths never trigger a reload.
+	Outputs []string
+
+	// Called when Cmd seems to reload itself, and reloads are paused
+	OnReloadLoop func()
+
+	// Retry refused connections to the upstream for this duration
+	UpstreamWait time.Duration
+
+	// Serve HTTPS. A local certificate is generated for CertHosts (and
+	// localhost), unless CertFile and KeyFile are set. Setting either file
+	// implies HTTPS, and requires the other.
+	HTTPS     bool
+	CertFile  string
+	KeyFile   string
+	CertHosts []string
+
+	// Extra CA bundle to verify HTTPS upstreams, and the client certificate
+	// presented to them. Insecure skips the verification.
+	UpstreamCA   string
+	UpstreamCert string
+	UpstreamKey  string
+	Insecure     bool
+
+	// Page served for the page requests that would be 404, such as
+	// DefaultFallback. Disabled if empty.
+	Fallback string
+
+	// Rewrite the absolute upstream URLs in HTML and CSS to the proxy
+	RewriteBody bool
+
+	// Rewrite the cookies set by the upstreams, unless the route has its own
+	Cookies CookieRewrite
+
+	// Host header sent to the upstream: HostUpstream, HostPreserve or a
+	// fixed host name
+	HostHeader string
+
+	// Changes to the headers of the forwarded requests
+	RequestHeaders []HeaderRule
+
+	// Changes to the headers of the proxied responses
+	ResponseHeaders []HeaderRule
+
+	// Disable caching of the proxied responses
+	NoCache bool
+
+	// Unregister the service workers of the page
+	UnregisterServiceWorkers bool
+
+	// Path globs to inject the reload script in, all pages if empty, and
+	// path globs never to inject it in
+	InjectOnly []string
+	NoInject   []string
+
+	// Path globs to inject the reload script in, even if the response does
+	// not look like an HTML page
+	ForceInject []string
+
+	// Detect HTML by its content when the upstream sends no Content-Type
+	Sniff bool
+
+	// Bearer token required by the control API, not required if empty
+	Token string
 }
 
 var hasSchemeRe = regexp.MustCompile(`^\s*[0-9A-Za-z.\-\+]+://`)
@@ -54,6 +145,64 @@ func (s *ServerOptions) SetDelay(delayMs int) error {
 	return nil
 }
 
+// Returns true if the server listens with HTTPS.
+func (s *ServerOptions) servesHTTPS() bool {
+	return s.HTTPS || s.CertFile != "" || s.KeyFile != ""
+}
+
+// Returns true if Cmd runs once before serving pages. BuildingPage and
+// Strict are about the startup command, and imply CmdAtStart.
+func (s *ServerOptions) runsCmdAtStart() bool {
+	return !s.Cmd.IsEmpty() && (s.CmdAtStart || s.BuildingPage || s.Strict)
+}
+
+func (s *ServerOptions) SetUpstreamWait(waitMs int) error {
+	s.UpstreamWait = time.Duration(max(0, waitMs)) * time.Millisecond
+	return nil
+}
+
+// AddRequestHeader parses the rule, and adds it to RequestHeaders.
+// See [ParseRequestHeader].
+func (s *ServerOptions) AddRequestHeader(rule string) error {
+	r, err := ParseRequestHeader(rule)
+	if err != nil {
+		return err
+	}
+	s.RequestHeaders = append(s.RequestHeaders, r)
+	return nil
+}
+
+// AddResponseHeader parses the rule, and adds it to ResponseHeaders.
+// See [ParseResponseHeader].
+func (s *ServerOptions) AddResponseHeader(rule string) error {
+	r, err := ParseResponseHeader(rule)
+	if err != nil {
+		return err
+	}
+	s.ResponseHeaders = append(s.ResponseHeaders, r)
+	return nil
+}
+
+// LoadResponseHeaders reads the response header rules from a file, one rule
+// per line in the form of [ParseResponseHeader]. Blank lines and lines
+// starting with # are ignored.
+func (s *ServerOptions) LoadResponseHeaders(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := s.AddResponseHeader(line); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+	}
+	return nil
+}
+
 func hasScheme(s string) bool {
 	return hasSchemeRe.Match([]byte(s))
 }
@@ -58,3 +58,17 @@ func Test_ParseHost(t *testing.T) {
 // 	harness.IsEqual(t, opt.Port, -1, "")
 // 	harness.IsEqual(t, opt.Forward.String(), "", "")
 // }
+
+func Test_runsCmdAtStart(t *testing.T) {
+	opt := NewServerOption()
+	opt.Strict = true
+	harness.IsFalse(t, opt.runsCmdAtStart(), "nothing to run without command")
+
+	opt.Cmd = Command{Line: "make"}
+	harness.IsTrue(t, opt.runsCmdAtStart(), "strict implies cmd at start")
+
+	opt.Strict = false
+	harness.IsFalse(t, opt.runsCmdAtStart(), "")
+	opt.BuildingPage = true
+	harness.IsTrue(t, opt.runsCmdAtStart(), "building page implies cmd at start")
+}
@@ -1,11 +1,18 @@
 package lib
 
 import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/yamavol/greload/test/harness"
+	"golang.org/x/net/websocket"
 )
 
 func Test_negativeDelay(t *testing.T) {
@@ -20,3 +27,186 @@ func Test_negativeDelay(t *testing.T) {
 	harness.IsTrue(t, testDelay <= defaultDebounceDuration, "delayMs is smaller than default")
 	harness.IsTrue(t, srv.adjustedDelayTime() == 0, "adjusted delay time is 0")
 }
+
+func Test_stop(t *testing.T) {
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	useTempCacheDir(t)
+	opt := NewServerOption()
+	opt.SetForwardHost("example.com")
+	opt.SetPort(port)
+	srv := NewServer(opt)
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Start() }()
+	for i := 0; i < 100 && !isListening(port); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	srv.Stop()
+	srv.Stop() // twice is fine
+	select {
+	case err := <-done:
+		harness.IsNil(t, err, "stopped without error")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after Stop")
+	}
+
+	states, _ := FindInstances()
+	harness.IsEqual(t, len(states), 0, "state file is removed")
+}
+
+func Test_stateOfAnyPort(t *testing.T) {
+	upstream := newTestUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html></html>"))
+	})
+
+	useTempCacheDir(t)
+	opt := NewServerOption()
+	opt.SetForwardHost(upstream.URL)
+	opt.SetPort(0)
+	srv := NewServer(opt)
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Start() }()
+	defer func() {
+		srv.Stop()
+		<-done
+	}()
+
+	var states []InstanceState
+	for i := 0; i < 100 && len(states) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		states, _ = FindInstances()
+	}
+	harness.IsEqual(t, len(states), 1, "state file of the listening port")
+	port := states[0].Port
+	harness.IsTrue(t, port != 0, "port chosen by the system")
+
+	_, body := doRequest(t, "GET", fmt.Sprintf("http://127.0.0.1:%d/", port))
+	harness.IsTrue(t, strings.Contains(body, fmt.Sprintf("://$1:%d", port)), "injected script connects to the port")
+}
+
+// Starts the upstream, closed at the end of the test.
+func newTestUpstream(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	upstream := httptest.NewServer(handler)
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+// Starts the proxy with the options, closed at the end of the test.
+func newTestProxy(t *testing.T, opt *ServerOptions) *httptest.Server {
+	handler, err := serverHandler(NewServer(opt))
+	harness.IsNil(t, err, "")
+	proxy := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+// Sends the request with the header name and value pairs, and returns the
+// response with its body. Redirects are not followed.
+func doRequest(t *testing.T, method string, url string, header ...string) (*http.Response, string) {
+	req, _ := http.NewRequest(method, url, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		if header[i] == "Host" {
+			req.Host = header[i+1]
+		} else {
+			req.Header.Set(header[i], header[i+1])
+		}
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+// Connects a reload client to the server, and waits until it is registered.
+func dialReloadClient(t *testing.T, srv *ProxyServer) *websocket.Conn {
+	handler, err := serverHandler(srv)
+	harness.IsNil(t, err, "")
+	ts := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(ts.Close)
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/", "", ts.URL)
+	harness.IsNil(t, err, "")
+	t.Cleanup(func() { conn.Close() })
+
+	for i := 0; i < 100 && srv.Status().Clients == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func Test_reloadSkippedOnBuildError(t *testing.T) {
+	opt := NewServerOption()
+	opt.SetForwardHost("example.com")
+	opt.Cmd = Command{Line: "exit 1"}
+	srv := NewServer(opt)
+	conn := dialReloadClient(t, srv)
+
+	srv.handleReload()
+
+	var msg message
+	harness.IsNil(t, websocket.JSON.Receive(conn, &msg), "")
+	harness.IsEqual(t, msg.Type, msgBuildError, "build error is shown, page is not reloaded")
+
+	conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	harness.IsNotNil(t, websocket.JSON.Receive(conn, &msg), "no reload follows")
+}
+
+func Test_reloadOnError(t *testing.T) {
+	opt := NewServerOption()
+	opt.SetForwardHost("example.com")
+	opt.Cmd = Command{Line: "exit 1"}
+	opt.ReloadOnError = true
+	srv := NewServer(opt)
+	conn := dialReloadClient(t, srv)
+
+	srv.handleReload()
+
+	var msg message
+	harness.IsNil(t, websocket.JSON.Receive(conn, &msg), "")
+	harness.IsEqual(t, msg.Type, msgReload, "page is reloaded despite the error")
+}
+
+func Test_loopDetectionNeedsCommand(t *testing.T) {
+	opt := NewServerOption()
+	opt.SetForwardHost("example.com")
+	srv := NewServer(opt)
+
+	// editors saving often
+	for i := 0; i <= loopThreshold; i++ {
+		srv.handleReload()
+	}
+	harness.IsFalse(t, srv.Paused(), "no loop without command")
+}
+
+func Test_loopPause(t *testing.T) {
+	opt := NewServerOption()
+	opt.SetForwardHost("example.com")
+	opt.Cmd = Command{Line: "exit 0"}
+	called := false
+	opt.OnReloadLoop = func() { called = true }
+	srv := NewServer(opt)
+
+	for i := 0; i <= loopThreshold; i++ {
+		srv.guard.record(time.Now())
+	}
+	srv.handleReload()
+	harness.IsTrue(t, called, "hook is called")
+	harness.IsTrue(t, srv.Paused(), "paused on loop")
+
+	srv.Resume()
+	harness.IsFalse(t, srv.Paused(), "paused until resumed")
+	harness.IsFalse(t, srv.guard.record(time.Now()), "resume forgets the loop")
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package lib
+
+import (
+	"os"
+	"syscall"
+)
+
+// Signals to shut down the server gracefully. On Windows, SIGTERM is sent
+// when the console is closed.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
@@ -0,0 +1,12 @@
+//go:build unix
+
+package lib
+
+import (
+	"os"
+	"syscall"
+)
+
+// Signals to shut down the server gracefully. SIGHUP is sent when the
+// terminal is closed.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP}
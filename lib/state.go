@@ -0,0 +1,160 @@
+package lib
+
+// ============================================================
+// Runtime state file, to find the running instances
+// ============================================================
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yamavol/greload/log"
+)
+
+// InstanceState describes a running server. It is written to the state
+// directory while the server runs.
+type InstanceState struct {
+	PID    int    `json:"pid"`
+	Port   int    `json:"port"`
+	Scheme string `json:"scheme"`
+	Token  string `json:"token,omitempty"`
+}
+
+// ControlURL returns the URL of the control API endpoint of the instance.
+func (s InstanceState) ControlURL(endpoint string) string {
+	scheme := s.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://127.0.0.1:%d%s%s", scheme, s.Port, ControlPrefix, endpoint)
+}
+
+// Returns the directory to keep the state files of the running instances.
+func stateDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "greload", "run"), nil
+}
+
+func statePath(port int) (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, fmt.Sprintf("%d.json", port)), nil
+}
+
+// Writes the state file, and returns the function to remove it. The file
+// holds the token, and is readable only by the user.
+func writeState(state InstanceState) (func(), error) {
+	path, err := statePath(state.Port)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(path, data, 0o600); err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Warnf("failed to remove state file: %v", err)
+		}
+	}, nil
+}
+
+func readState(path string) (InstanceState, error) {
+	var state InstanceState
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+// Returns true if the port accepts connections.
+func isListening(port int) bool {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// FindInstances returns the states of the running instances, sorted by
+// port. The state files left by the crashed instances are removed.
+func FindInstances() ([]InstanceState, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	states := []InstanceState{}
+	for _, path := range paths {
+		state, err := readState(path)
+		if err != nil {
+			log.Debugf("invalid state file %s: %v", path, err)
+			continue
+		}
+		if !isListening(state.Port) {
+			log.Debugf("removing stale state file %s", path)
+			os.Remove(path)
+			continue
+		}
+		states = append(states, state)
+	}
+	slices.SortFunc(states, func(a, b InstanceState) int { return a.Port - b.Port })
+	return states, nil
+}
+
+// FindInstance returns the state of the instance on port. If port is 0, it
+// returns the only running instance.
+func FindInstance(port int) (InstanceState, error) {
+	if port != 0 {
+		path, err := statePath(port)
+		if err == nil {
+			if state, err := readState(path); err == nil && state.Port == port {
+				return state, nil
+			}
+		}
+		// started by another user, or without state file
+		return InstanceState{Port: port, Scheme: "http"}, nil
+	}
+
+	states, err := FindInstances()
+	if err != nil {
+		return InstanceState{}, err
+	}
+	switch len(states) {
+	case 0:
+		return InstanceState{}, errors.New("no running greload instance found")
+	case 1:
+		return states[0], nil
+	default:
+		ports := []string{}
+		for _, state := range states {
+			ports = append(ports, fmt.Sprint(state.Port))
+		}
+		return InstanceState{}, fmt.Errorf("several greload instances are running (ports %s), use --port", strings.Join(ports, ", "))
+	}
+}
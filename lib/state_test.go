@@ -0,0 +1,68 @@
+package lib
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/yamavol/greload/test/harness"
+)
+
+// Points os.UserCacheDir to a temporary directory.
+func useTempCacheDir(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("cache directory is overridden by XDG_CACHE_HOME on linux only")
+	}
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+}
+
+func Test_findInstance(t *testing.T) {
+	useTempCacheDir(t)
+
+	_, err := FindInstance(0)
+	harness.IsNotNil(t, err, "no instance is running")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	harness.IsNil(t, err, "")
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	remove, err := writeState(InstanceState{PID: os.Getpid(), Port: port, Scheme: "https", Token: "secret"})
+	harness.IsNil(t, err, "")
+
+	state, err := FindInstance(0)
+	harness.IsNil(t, err, "")
+	harness.IsEqual(t, state.Port, port, "the only instance is found")
+	harness.IsEqual(t, state.Token, "secret", "")
+	harness.IsEqual(t, state.ControlURL("status"), "https://127.0.0.1:"+strconv.Itoa(port)+"/__greload/status", "")
+
+	state, err = FindInstance(port)
+	harness.IsNil(t, err, "")
+	harness.IsEqual(t, state.Scheme, "https", "state of the port is used")
+
+	remove()
+	state, err = FindInstance(port)
+	harness.IsNil(t, err, "")
+	harness.IsEqual(t, state.Scheme, "http", "port without state file")
+}
+
+func Test_findInstancesRemovesStale(t *testing.T) {
+	useTempCacheDir(t)
+
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	_, err := writeState(InstanceState{Port: port})
+	harness.IsNil(t, err, "")
+
+	states, err := FindInstances()
+	harness.IsNil(t, err, "")
+	harness.IsEqual(t, len(states), 0, "crashed instance is ignored")
+
+	path, _ := statePath(port)
+	_, err = os.Stat(path)
+	harness.IsTrue(t, os.IsNotExist(err), "stale state file is removed")
+}
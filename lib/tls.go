@@ -0,0 +1,94 @@
+package lib
+
+// ============================================================
+// TLS configuration
+// ============================================================
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/yamavol/greload/lib/internal"
+	"github.com/yamavol/greload/log"
+)
+
+// Returns the directory to keep the generated certificates.
+func certDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "greload", "certs"), nil
+}
+
+// Returns the TLS config of the HTTPS listener. The user-supplied
+// certificate is used if set, otherwise a local certificate is generated.
+func (s *ServerOptions) serverTLSConfig() (*tls.Config, error) {
+	if s.CertFile != "" || s.KeyFile != "" {
+		if s.CertFile == "" || s.KeyFile == "" {
+			return nil, errors.New("both certificate and key files are required")
+		}
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+	}
+
+	dir, err := certDir()
+	if err != nil {
+		return nil, err
+	}
+	cert, caPath, err := internal.LocalCert(dir, s.CertHosts)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("using local development certificate. trust this CA to avoid warnings:", caPath)
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// Returns the TLS config to connect to HTTPS upstreams, or nil to use the
+// default verification.
+func (s *ServerOptions) upstreamTLSConfig() (*tls.Config, error) {
+	if s.UpstreamCA == "" && s.UpstreamCert == "" && s.UpstreamKey == "" && !s.Insecure {
+		return nil, nil
+	}
+
+	config := &tls.Config{}
+
+	if s.UpstreamCA != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		bundle, err := os.ReadFile(s.UpstreamCA)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates found in %s", s.UpstreamCA)
+		}
+		config.RootCAs = pool
+	}
+
+	if s.UpstreamCert != "" || s.UpstreamKey != "" {
+		if s.UpstreamCert == "" || s.UpstreamKey == "" {
+			return nil, errors.New("both client certificate and key files are required")
+		}
+		cert, err := tls.LoadX509KeyPair(s.UpstreamCert, s.UpstreamKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if s.Insecure {
+		log.Warn("WARNING: upstream certificates are not verified (--insecure)")
+		config.InsecureSkipVerify = true
+	}
+	return config, nil
+}
@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yamavol/greload/test/harness"
+)
+
+func Test_upstreamTLSConfig(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw})
+	harness.IsNil(t, os.WriteFile(caFile, caPem, 0o644), "")
+
+	opt := NewServerOption()
+	config, err := opt.upstreamTLSConfig()
+	harness.IsNil(t, err, "")
+	harness.IsTrue(t, config == nil, "default verification without options")
+
+	opt.UpstreamCA = caFile
+	config, err = opt.upstreamTLSConfig()
+	harness.IsNil(t, err, "")
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	resp, err := client.Get(upstream.URL)
+	harness.IsNil(t, err, "upstream should be trusted with the extra CA")
+	if err == nil {
+		resp.Body.Close()
+	}
+
+	opt.UpstreamCA = ""
+	opt.UpstreamCert = caFile
+	_, err = opt.upstreamTLSConfig()
+	harness.IsNotNil(t, err, "client certificate requires a key")
+}
+
+func Test_serverTLSConfigNeedsPair(t *testing.T) {
+	opt := NewServerOption()
+	harness.IsFalse(t, opt.servesHTTPS(), "http by default")
+
+	opt.KeyFile = "server.key"
+	harness.IsTrue(t, opt.servesHTTPS(), "key file implies https")
+	_, err := opt.serverTLSConfig()
+	harness.IsNotNil(t, err, "key without certificate is an error")
+
+	opt.KeyFile = ""
+	opt.CertFile = "server.crt"
+	harness.IsTrue(t, opt.servesHTTPS(), "certificate file implies https")
+	_, err = opt.serverTLSConfig()
+	harness.IsNotNil(t, err, "certificate without key is an error")
+}
@@ -0,0 +1,121 @@
+package lib
+
+// ============================================================
+// Upstream errors
+// ============================================================
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/yamavol/greload/lib/internal"
+	"github.com/yamavol/greload/log"
+)
+
+const (
+	upstreamProbeInterval = 500 * time.Millisecond
+	upstreamProbeTimeout  = 2 * time.Second
+)
+
+// For ReverseProxy.ErrorHandler. Serves a page with the reload script, and
+// reloads it once the upstream responds again.
+func upstreamErrorHandler(srv *ProxyServer) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		if errors.Is(err, context.Canceled) {
+			// the browser went away, nobody reads the page
+			return
+		}
+
+		status, reason := describeUpstreamError(err)
+		log.Errorf("[proxy] %s %s: %v", r.Method, r.URL, err)
+
+		page := internal.UnavailablePage(srv.clientOptions(), r.URL.Host, reason, err)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		w.Write(page)
+
+		// an upstream that answers with an error is not waited for, the
+		// reloaded page would fail again
+		if isUpstreamDown(err) && (r.URL.Scheme == "http" || r.URL.Scheme == "https") {
+			go srv.probeUpstream(r.URL.Scheme + "://" + r.URL.Host)
+		}
+	}
+}
+
+// Returns true if the upstream is not running or not responding, likely
+// restarting.
+func isUpstreamDown(err error) bool {
+	var netErr net.Error
+	return internal.IsConnRefused(err) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Returns the status code and a short description of the proxy error.
+func describeUpstreamError(err error) (int, string) {
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var unknownAuthErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+
+	switch {
+	case internal.IsConnRefused(err):
+		return http.StatusBadGateway, "connection refused"
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout, "timeout"
+	case errors.As(err, &certErr),
+		errors.As(err, &recordErr),
+		errors.As(err, &unknownAuthErr),
+		errors.As(err, &hostnameErr):
+		return http.StatusBadGateway, "TLS failure"
+	default:
+		return http.StatusBadGateway, "upstream error"
+	}
+}
+
+// Polls the upstream until it responds to HTTP requests, then reloads the
+// clients. Only one probe runs for each upstream origin.
+func (srv *ProxyServer) probeUpstream(origin string) {
+	srv.mu.Lock()
+	if srv.probing[origin] {
+		srv.mu.Unlock()
+		return
+	}
+	srv.probing[origin] = true
+	srv.mu.Unlock()
+
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.probing, origin)
+		srv.mu.Unlock()
+	}()
+
+	client := &http.Client{
+		Transport: srv.upstream,
+		Timeout:   upstreamProbeTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	log.Debug("[proxy] waiting for", origin)
+	for {
+		time.Sleep(upstreamProbeInterval)
+		// any response, even an error status, means the upstream is running
+		resp, err := client.Head(origin + "/")
+		if err == nil {
+			resp.Body.Close()
+			log.Info("[proxy] upstream is back:", origin)
+			srv.broadcast(message{Type: msgReload})
+			return
+		}
+	}
+}
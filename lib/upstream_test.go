@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yamavol/greload/test/harness"
+	"golang.org/x/net/websocket"
+)
+
+func Test_describeUpstreamError(t *testing.T) {
+	// reserve a port, then close it so that nothing listens there
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	harness.IsNil(t, err, "")
+	addr := l.Addr().String()
+	l.Close()
+
+	// a real dial, the error differs between the platforms
+	_, err = net.Dial("tcp", addr)
+	status, reason := describeUpstreamError(err)
+	harness.IsEqual(t, status, http.StatusBadGateway, "")
+	harness.IsEqual(t, reason, "connection refused", "")
+	harness.IsTrue(t, isUpstreamDown(err), "refused upstream is probed")
+
+	status, reason = describeUpstreamError(context.DeadlineExceeded)
+	harness.IsEqual(t, status, http.StatusGatewayTimeout, "")
+	harness.IsEqual(t, reason, "timeout", "")
+
+	status, reason = describeUpstreamError(errors.New("unexpected EOF"))
+	harness.IsEqual(t, status, http.StatusBadGateway, "")
+	harness.IsEqual(t, reason, "upstream error", "")
+}
+
+func Test_noProbeForFailingUpstream(t *testing.T) {
+	// listening, but its certificate is not trusted
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	opt := NewServerOption()
+	opt.SetForwardHost(upstream.URL)
+	srv := NewServer(opt)
+	conn := dialReloadClient(t, srv)
+	handler, _ := serverHandler(srv)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/", nil))
+	harness.IsEqual(t, w.Code, http.StatusBadGateway, "")
+
+	var msg message
+	conn.SetReadDeadline(time.Now().Add(3 * upstreamProbeInterval))
+	harness.IsNotNil(t, websocket.JSON.Receive(conn, &msg), "no reload for an upstream that fails the same way")
+}
+
+func Test_probeRestartedUpstream(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	harness.IsNil(t, err, "")
+	addr := l.Addr().String()
+	l.Close()
+
+	opt := NewServerOption()
+	opt.SetForwardHost("http://" + addr)
+	srv := NewServer(opt)
+	conn := dialReloadClient(t, srv)
+	handler, _ := serverHandler(srv)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/", nil))
+	harness.IsEqual(t, w.Code, http.StatusBadGateway, "connection refused")
+
+	// the upstream restarts
+	l, err = net.Listen("tcp", addr)
+	harness.IsNil(t, err, "")
+	upstream := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go upstream.Serve(l)
+	defer upstream.Close()
+
+	var msg message
+	harness.IsNil(t, websocket.JSON.Receive(conn, &msg), "")
+	harness.IsEqual(t, msg.Type, msgReload, "reloaded when the upstream is back")
+}